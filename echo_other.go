@@ -0,0 +1,13 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// disableEcho isn't supported here, so passwords can't be hidden.
+func disableEcho(f *os.File) (func(), error) {
+	return nil, errors.New("hiding input isn't supported on this system")
+}
@@ -0,0 +1,35 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// disableEcho stops the terminal f from echoing what is typed, keeping it
+// in line mode, and returns a function that restores the old settings.
+func disableEcho(f *os.File) (func(), error) {
+	fd := f.Fd()
+	var saved syscall.Termios
+	if err := termios(fd, ioctlReadTermios, &saved); err != nil {
+		return nil, err
+	}
+	quiet := saved
+	quiet.Lflag &^= syscall.ECHO
+	quiet.Lflag |= syscall.ICANON | syscall.ISIG
+	if err := termios(fd, ioctlWriteTermios, &quiet); err != nil {
+		return nil, err
+	}
+	return func() {
+		termios(fd, ioctlWriteTermios, &saved)
+	}, nil
+}
+
+func termios(fd uintptr, request uintptr, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
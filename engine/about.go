@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// ErrUnknownPage is returned for about: URLs naming no built-in page.
+var ErrUnknownPage = errors.New("unknown page")
+
+// aboutPages generate the built-in about: pages from the engine's state.
+var aboutPages = map[string]func(e *Engine) string{
+	"blank":       aboutBlank,
+	"cache":       aboutCache,
+	"cookies":     aboutCookies,
+	"connections": aboutConnections,
+	"version":     aboutVersion,
+}
+
+// loadAbout serves about: URLs, such as about:blank or about:cache, as
+// generated HTML pages.
+func loadAbout(e *Engine, url *URL, headers map[string]string) (*Response, error) {
+	name := strings.ToLower(url.path)
+	if name == "" {
+		name = "blank"
+	}
+	page, ok := aboutPages[name]
+	if !ok {
+		return nil, requestError(url, ErrUnknownPage, nil)
+	}
+	return &Response{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "text/html; charset=utf-8"},
+		Body:       []byte(page(e)),
+	}, nil
+}
+
+// aboutDocument wraps the lines of a page in an HTML document, one line of
+// text each so that it reads well once the tags are stripped.
+func aboutDocument(title string, lines []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<html><body>\n<h1>%s</h1>\n", html.EscapeString(title))
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.WriteString("</body></html>\n")
+	return b.String()
+}
+
+func aboutBlank(e *Engine) string {
+	return "<html><body></body></html>\n"
+}
+
+func aboutCache(e *Engine) string {
+	stats := e.CacheStats()
+	lines := []string{
+		fmt.Sprintf("<p>Entries: %d, size: %d bytes, hits: %d, misses: %d, evictions: %d</p>",
+			stats.Entries, stats.Bytes, stats.Hits, stats.Misses, stats.Evictions),
+	}
+	if _, ok := e.cache.(CacheLister); !ok {
+		lines = append(lines, "<p>The cache storage in use can't list its entries.</p>")
+		return aboutDocument("Cache", lines)
+	}
+	entries := e.CacheEntries()
+	if len(entries) == 0 {
+		lines = append(lines, "<p>The cache is empty.</p>")
+		return aboutDocument("Cache", lines)
+	}
+	now := time.Now()
+	lines = append(lines, "<ul>")
+	for _, entry := range entries {
+		expiry := "never expires"
+		if !entry.ExpiresAt.IsZero() {
+			if entry.ExpiresAt.After(now) {
+				expiry = fmt.Sprintf("expires %s (in %s)", entry.ExpiresAt.Format(time.RFC3339), entry.ExpiresAt.Sub(now).Round(time.Second))
+			} else {
+				expiry = fmt.Sprintf("expired %s", entry.ExpiresAt.Format(time.RFC3339))
+			}
+		}
+		line := fmt.Sprintf("%s %d, %d bytes, %s", entry.URL, entry.StatusCode, entry.Size, expiry)
+		if len(entry.Vary) > 0 {
+			line += fmt.Sprintf(", varies on %v", entry.Vary)
+		}
+		lines = append(lines, "<li>"+html.EscapeString(line)+"</li>")
+	}
+	lines = append(lines, "</ul>")
+	return aboutDocument("Cache", lines)
+}
+
+// aboutCookies lists the cookie jar. The engine doesn't store cookies
+// yet, so the jar is always empty.
+func aboutCookies(e *Engine) string {
+	return aboutDocument("Cookies", []string{"<p>The cookie jar is empty.</p>"})
+}
+
+func aboutConnections(e *Engine) string {
+	conns := e.PooledConnections()
+	if len(conns) == 0 {
+		return aboutDocument("Connections", []string{"<p>No connections are open.</p>"})
+	}
+	lines := []string{"<ul>"}
+	for _, conn := range conns {
+		line := fmt.Sprintf("%s %s", conn.Key, conn.Proto)
+		if conn.RemoteAddr != "" {
+			line += " to " + conn.RemoteAddr
+		}
+		if conn.Proto == "HTTP/2.0" {
+			line += fmt.Sprintf(", %d active streams", conn.ActiveStreams)
+		} else {
+			line += ", idle"
+		}
+		lines = append(lines, "<li>"+html.EscapeString(line)+"</li>")
+	}
+	lines = append(lines, "</ul>")
+	return aboutDocument("Connections", lines)
+}
+
+func aboutVersion(e *Engine) string {
+	lines := []string{
+		fmt.Sprintf("<p>Go: %s %s/%s</p>", html.EscapeString(runtime.Version()), runtime.GOOS, runtime.GOARCH),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path != "" {
+			lines = append(lines, fmt.Sprintf("<p>Module: %s %s</p>", html.EscapeString(info.Main.Path), html.EscapeString(info.Main.Version)))
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision", "vcs.time", "vcs.modified":
+				lines = append(lines, fmt.Sprintf("<p>%s: %s</p>", html.EscapeString(setting.Key), html.EscapeString(setting.Value)))
+			}
+		}
+	}
+	return aboutDocument("Version", lines)
+}
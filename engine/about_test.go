@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestAboutPages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "<b>cached</b>")
+	}))
+	defer server.Close()
+
+	e := NewEngine()
+	url, err := Parse(server.URL + "/page?a=<b>")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	// Keep-alive leaves the connection in the pool for about:connections.
+	if _, err := e.Request(url, map[string]string{"Connection": "keep-alive"}); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	testCases := []struct {
+		url      string
+		expected []string
+	}{
+		{"about:blank", []string{"<body></body>"}},
+		{"about:", []string{"<body></body>"}},
+		{"about:cache", []string{"Entries: 1", server.URL + "/page?a=&lt;b&gt; 200", "expires"}},
+		{"about:cookies", []string{"<h1>Cookies</h1>", "The cookie jar is empty."}},
+		{"about:connections", []string{strings.TrimPrefix(server.URL, "http://") + " HTTP/1.1 to ", ", idle"}},
+		{"about:version", []string{runtime.Version()}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.url, func(t *testing.T) {
+			url, err := Parse(tc.url)
+			if err != nil {
+				t.Fatalf("failed to parse URL: %v", err)
+			}
+			r, err := e.Request(url, nil)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if contentType := r.Headers["Content-Type"]; contentType != "text/html; charset=utf-8" {
+				t.Errorf("expected %q, got %q", "text/html; charset=utf-8", contentType)
+			}
+			for _, want := range tc.expected {
+				if !strings.Contains(string(r.Body), want) {
+					t.Errorf("expected %q in page:\n%s", want, r.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestAboutUnknownPage(t *testing.T) {
+	url, err := Parse("about:nothing")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	_, err = NewEngine().Request(url, nil)
+	var engineErr *Error
+	if !errors.As(err, &engineErr) || engineErr.Kind != ErrUnknownPage || engineErr.URL != "about:nothing" {
+		t.Errorf("expected an ErrUnknownPage error for about:nothing, got %v", err)
+	}
+}
+
+func TestCacheEntries(t *testing.T) {
+	disk, err := OpenDiskCache(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open disk cache: %v", err)
+	}
+	for _, storage := range []CacheStorage{NewMemoryCache(0, 0), disk} {
+		storage.Store("a", NewCacheValue(&Response{URL: "http://a/", StatusCode: 200, Body: []byte("aaa")}, 60))
+		storage.Store("b", NewCacheValue(&Response{URL: "http://b/", StatusCode: 200, Body: []byte("b")}, 60))
+
+		entries := storage.(CacheLister).Entries()
+		if len(entries) != 2 {
+			t.Fatalf("%T: expected 2 entries, got %d", storage, len(entries))
+		}
+		// The most recently used entry comes first.
+		if entries[0].URL != "http://b/" || entries[1].URL != "http://a/" {
+			t.Errorf("%T: expected b then a, got %q then %q", storage, entries[0].URL, entries[1].URL)
+		}
+		if entries[0].ExpiresAt.IsZero() {
+			t.Errorf("%T: expected an expiry time", storage)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+)
+
+// ErrNotInArchive is returned while replaying when the archive has no
+// response for a request.
+var ErrNotInArchive = errors.New("not in archive")
+
+// ArchiveEntry is one request the engine sent over the network and the
+// response it got back, before redirects were followed or the body was
+// decompressed.
+type ArchiveEntry struct {
+	URL            string            `json:"url"`
+	RequestHeaders map[string]string `json:"request_headers"`
+	StatusCode     int               `json:"status_code"`
+	Proto          string            `json:"proto"`
+	Reason         string            `json:"reason,omitempty"`
+	Headers        map[string]string `json:"headers"`
+	Body           []byte            `json:"body"`
+}
+
+// Archive holds the network traffic of page loads so they can be replayed
+// later without a network. Record into one with WithRecorder and play it
+// back with WithReplay.
+type Archive struct {
+	mu      sync.Mutex
+	Entries []ArchiveEntry `json:"entries"`
+	// replayed counts how many responses for each URL have been replayed,
+	// so repeated requests get the responses in the order they were
+	// recorded.
+	replayed map[string]int
+}
+
+// NewArchive creates an empty archive.
+func NewArchive() *Archive {
+	return &Archive{}
+}
+
+// LoadArchive reads an archive saved with Save.
+func LoadArchive(path string) (*Archive, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	a := NewArchive()
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, fmt.Errorf("invalid archive %s: %v", path, err)
+	}
+	return a, nil
+}
+
+// Save writes the archive to path as JSON.
+func (a *Archive) Save(path string) error {
+	a.mu.Lock()
+	data, err := json.MarshalIndent(a, "", "  ")
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// WithRecorder appends every request the engine sends over the network,
+// including each redirect hop, to archive.
+func WithRecorder(archive *Archive) Option {
+	return func(e *Engine) {
+		e.recorder = archive
+	}
+}
+
+// WithReplay answers network requests from archive instead of connecting
+// anywhere. Requests missing from the archive fail with ErrNotInArchive.
+func WithReplay(archive *Archive) Option {
+	return func(e *Engine) {
+		e.replay = archive
+	}
+}
+
+func (a *Archive) record(url *URL, headers map[string]string, r *Response) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Entries = append(a.Entries, ArchiveEntry{
+		URL:            url.String(),
+		RequestHeaders: maps.Clone(headers),
+		StatusCode:     r.StatusCode,
+		Proto:          r.Proto,
+		Reason:         r.Reason,
+		Headers:        maps.Clone(r.Headers),
+		Body:           slices.Clone(r.Body),
+	})
+}
+
+// replay returns the next recorded response for url. Once they have all
+// been used the last one keeps being returned.
+func (a *Archive) replay(url *URL) (*Response, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := url.String()
+	var matches []*ArchiveEntry
+	for i := range a.Entries {
+		if a.Entries[i].URL == key {
+			matches = append(matches, &a.Entries[i])
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%w: GET %s", ErrNotInArchive, key)
+	}
+	if a.replayed == nil {
+		a.replayed = make(map[string]int)
+	}
+	entry := matches[min(a.replayed[key], len(matches)-1)]
+	a.replayed[key]++
+	return &Response{
+		StatusCode: entry.StatusCode,
+		Proto:      entry.Proto,
+		Reason:     entry.Reason,
+		Headers:    maps.Clone(entry.Headers),
+		Body:       slices.Clone(entry.Body),
+	}, nil
+}
@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	var visits int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		visits++
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		fmt.Fprintf(gz, "visit %d", visits)
+		gz.Close()
+	})
+
+	archive := NewArchive()
+	recorder := NewEngine(WithTransport(newPipeTransport(t, mux)), WithRecorder(archive))
+	for _, rawURL := range []string{"http://example.com/old", "http://example.com/page"} {
+		url, err := Parse(rawURL)
+		if err != nil {
+			t.Fatalf("failed to parse URL: %v", err)
+		}
+		if _, err := recorder.Request(url, nil); err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+	}
+	if len(archive.Entries) != 3 {
+		t.Fatalf("expected 3 recorded requests, got %d", len(archive.Entries))
+	}
+	if archive.Entries[0].StatusCode != http.StatusFound {
+		t.Errorf("expected the redirect to be recorded, got %d", archive.Entries[0].StatusCode)
+	}
+
+	path := filepath.Join(t.TempDir(), "archive.json")
+	if err := archive.Save(path); err != nil {
+		t.Fatalf("failed to save archive: %v", err)
+	}
+	loaded, err := LoadArchive(path)
+	if err != nil {
+		t.Fatalf("failed to load archive: %v", err)
+	}
+
+	replayer := NewEngine(WithTransport(failingTransport{}), WithReplay(loaded))
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"http://example.com/old", "visit 1"},
+		{"http://example.com/page", "visit 2"},
+		{"http://example.com/page", "visit 2"},
+	}
+	for _, tt := range tests {
+		url, err := Parse(tt.url)
+		if err != nil {
+			t.Fatalf("failed to parse URL: %v", err)
+		}
+		response, err := replayer.Request(url, nil)
+		if err != nil {
+			t.Fatalf("replay of %q failed: %v", tt.url, err)
+		}
+		if string(response.Body) != tt.expected {
+			t.Errorf("for URL %q, expected %q, got %q", tt.url, tt.expected, response.Body)
+		}
+	}
+
+	url, err := Parse("http://example.com/missing")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	if _, err := replayer.Request(url, nil); !errors.Is(err, ErrNotInArchive) {
+		t.Errorf("expected %v, got %v", ErrNotInArchive, err)
+	}
+}
@@ -0,0 +1,419 @@
+package engine
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"maps"
+	neturl "net/url"
+	"strings"
+)
+
+// MAX_AUTH_ATTEMPTS is how many times a request is retried with new
+// credentials after a 401 before the 401 is returned.
+const MAX_AUTH_ATTEMPTS = 3
+
+// AuthRequest describes a server asking for credentials.
+type AuthRequest struct {
+	URL string
+	// Host is the host name and port the credentials are for.
+	Host string
+	// Scheme is the authentication scheme, "Basic" or "Digest".
+	Scheme string
+	Realm  string
+	// Attempt counts the requests for credentials for this URL, starting
+	// at 1, so callers can tell when earlier credentials were rejected.
+	Attempt int
+}
+
+// CredentialsFunc supplies credentials for a server's challenge. It
+// returns false to give up, in which case the 401 response is returned.
+type CredentialsFunc func(req AuthRequest) (username, password string, ok bool)
+
+// WithCredentials makes the engine ask credentials for a 401 response
+// when the URL carries none or the ones it carried were rejected. The
+// engine remembers credentials the server accepted and sends them up
+// front with later requests to the same protection space.
+func WithCredentials(credentials CredentialsFunc) Option {
+	return func(e *Engine) {
+		e.credentials = credentials
+	}
+}
+
+type authChallenge struct {
+	scheme string // lowercased
+	params map[string]string
+}
+
+// authGrant is a set of credentials the engine sent in answer to a
+// challenge.
+type authGrant struct {
+	challenge          authChallenge
+	username, password string
+	// nc counts the requests made with the challenge's digest nonce.
+	nc int
+}
+
+// authorization returns the Authorization header for a request naming
+// target on its request line.
+func (g *authGrant) authorization(target string) (string, error) {
+	if g.challenge.scheme == "basic" {
+		return basicAuthorization(g.username, g.password), nil
+	}
+	return digestAuthorization(g.challenge, g.username, g.password, "GET", target, g.nc, randomCnonce())
+}
+
+// authSpace is a protection space, an origin and realm, that accepted a
+// set of credentials. Requests for paths under dir send them up front.
+type authSpace struct {
+	grant authGrant
+	dir   string
+}
+
+func authSpaceKey(url *URL, realm string) string {
+	return url.origin() + " " + realm
+}
+
+// authDir returns the directory of url's path, which the protection
+// space of a challenge for url is assumed to cover.
+func authDir(url *URL) string {
+	path, _, _ := strings.Cut(url.path, "?")
+	return path[:strings.LastIndexByte(path, '/')+1]
+}
+
+// rememberAuth records that the server accepted grant for url.
+func (e *Engine) rememberAuth(url *URL, grant *authGrant) {
+	e.authMu.Lock()
+	defer e.authMu.Unlock()
+	if e.authSpaces == nil {
+		e.authSpaces = make(map[string]*authSpace)
+	}
+	key, dir := authSpaceKey(url, grant.challenge.params["realm"]), authDir(url)
+	space, ok := e.authSpaces[key]
+	if !ok {
+		e.authSpaces[key] = &authSpace{grant: *grant, dir: dir}
+		return
+	}
+	// Spread the space over both directories.
+	for !strings.HasPrefix(dir, space.dir) {
+		space.dir = space.dir[:strings.LastIndexByte(strings.TrimSuffix(space.dir, "/"), '/')+1]
+	}
+	nc := grant.nc
+	if space.grant.challenge.params["nonce"] == grant.challenge.params["nonce"] {
+		nc = max(nc, space.grant.nc)
+	}
+	space.grant = *grant
+	space.grant.nc = nc
+}
+
+// forgetAuth drops the protection space of grant after the server
+// rejected it.
+func (e *Engine) forgetAuth(url *URL, grant *authGrant) {
+	e.authMu.Lock()
+	defer e.authMu.Unlock()
+	delete(e.authSpaces, authSpaceKey(url, grant.challenge.params["realm"]))
+}
+
+// rememberedAuth returns the credentials to send up front for url, from
+// the deepest protection space covering it, counting another use of its
+// nonce.
+func (e *Engine) rememberedAuth(url *URL) (*authGrant, bool) {
+	e.authMu.Lock()
+	defer e.authMu.Unlock()
+	origin, dir := url.origin(), authDir(url)
+	var best *authSpace
+	for key, space := range e.authSpaces {
+		if strings.HasPrefix(key, origin+" ") && strings.HasPrefix(dir, space.dir) && (best == nil || len(space.dir) > len(best.dir)) {
+			best = space
+		}
+	}
+	if best == nil {
+		return nil, false
+	}
+	if url.username != "" && (url.username != best.grant.username || url.password != best.grant.password) {
+		// Credentials in the URL win over remembered ones.
+		return nil, false
+	}
+	best.grant.nc++
+	grant := best.grant
+	return &grant, true
+}
+
+// rememberedRealm returns the credentials the server accepted for realm
+// at url's origin.
+func (e *Engine) rememberedRealm(url *URL, realm string) (authGrant, bool) {
+	e.authMu.Lock()
+	defer e.authMu.Unlock()
+	space, ok := e.authSpaces[authSpaceKey(url, realm)]
+	if !ok {
+		return authGrant{}, false
+	}
+	return space.grant, true
+}
+
+// authenticate works out how to retry a request that got the 401 r. It
+// returns the URL, carrying the credentials used, and the headers to
+// retry with, or false if the response should be returned as it is.
+func (e *Engine) authenticate(url *URL, proxyURL *neturl.URL, headers map[string]string, r *Response) (*URL, map[string]string, bool) {
+	header, _ := getHeader(r.Headers, "WWW-Authenticate")
+	challenge, ok := pickChallenge(parseChallenges(header))
+	stale := ok && challenge.scheme == "digest" && strings.EqualFold(challenge.params["stale"], "true")
+	if url.auth != nil && !stale {
+		e.forgetAuth(url, url.auth)
+	}
+	if !ok || url.authAttempts >= MAX_AUTH_ATTEMPTS {
+		return nil, nil, false
+	}
+
+	retry := *url
+	retry.authAttempts++
+	remembered, known := e.rememberedRealm(url, challenge.params["realm"])
+	switch {
+	case url.authAttempts == 0 && url.username != "":
+		// Try the credentials from the URL first.
+	case url.auth != nil && stale:
+		// Only the nonce expired, the credentials were fine.
+		retry.username, retry.password = url.auth.username, url.auth.password
+	case url.auth == nil && known:
+		// The server accepted these for the realm before.
+		retry.username, retry.password = remembered.username, remembered.password
+	default:
+		if e.credentials == nil {
+			return nil, nil, false
+		}
+		username, password, ok := e.credentials(AuthRequest{
+			URL:     url.String(),
+			Host:    url.hostWithPort(),
+			Scheme:  authSchemeName(challenge.scheme),
+			Realm:   challenge.params["realm"],
+			Attempt: url.authAttempts + 1,
+		})
+		if !ok {
+			return nil, nil, false
+		}
+		retry.username, retry.password = username, password
+	}
+
+	retry.auth = &authGrant{challenge: challenge, username: retry.username, password: retry.password, nc: 1}
+	authorization, err := retry.auth.authorization(requestTarget(url, proxyURL))
+	if err != nil {
+		return nil, nil, false
+	}
+	retryHeaders := maps.Clone(headers)
+	retryHeaders["Authorization"] = authorization
+	return &retry, retryHeaders, true
+}
+
+func authSchemeName(scheme string) string {
+	if scheme == "basic" {
+		return "Basic"
+	}
+	return "Digest"
+}
+
+// pickChallenge chooses the strongest challenge the engine supports.
+func pickChallenge(challenges []authChallenge) (authChallenge, bool) {
+	best, bestRank := authChallenge{}, 0
+	for _, c := range challenges {
+		rank := 0
+		switch c.scheme {
+		case "basic":
+			rank = 1
+		case "digest":
+			if !digestSupportsQop(c.params["qop"]) {
+				continue
+			}
+			switch strings.ToUpper(c.params["algorithm"]) {
+			case "", "MD5", "MD5-SESS":
+				rank = 2
+			case "SHA-256", "SHA-256-SESS":
+				rank = 3
+			}
+		}
+		if rank > bestRank {
+			best, bestRank = c, rank
+		}
+	}
+	return best, bestRank > 0
+}
+
+// digestSupportsQop reports whether a qop directive allows qop=auth, or
+// is missing for the RFC 2069 style of digest.
+func digestSupportsQop(qop string) bool {
+	if qop == "" {
+		return true
+	}
+	for _, option := range strings.Split(qop, ",") {
+		if strings.EqualFold(strings.TrimSpace(option), "auth") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseChallenges parses a WWW-Authenticate header, which may hold several
+// challenges separated by commas like their parameters are.
+func parseChallenges(header string) []authChallenge {
+	var challenges []authChallenge
+	p := &headerParser{s: header}
+	for {
+		p.skip(", \t")
+		if p.pos >= len(p.s) {
+			break
+		}
+		scheme := p.token()
+		if scheme == "" {
+			// Stray characters, such as the padding of a token68.
+			p.pos++
+			continue
+		}
+		c := authChallenge{scheme: strings.ToLower(scheme), params: make(map[string]string)}
+		for {
+			p.skip(" \t")
+			mark := p.pos
+			name := p.token()
+			p.skip(" \t")
+			if name == "" || !p.consume('=') {
+				// Not a parameter but the start of the next challenge.
+				p.pos = mark
+				break
+			}
+			p.skip(" \t")
+			c.params[strings.ToLower(name)] = p.value()
+			p.skip(" \t")
+			if !p.consume(',') {
+				break
+			}
+			p.skip(", \t")
+		}
+		challenges = append(challenges, c)
+	}
+	return challenges
+}
+
+type headerParser struct {
+	s   string
+	pos int
+}
+
+func (p *headerParser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *headerParser) consume(c byte) bool {
+	if p.peek() == c && c != 0 {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *headerParser) skip(chars string) {
+	for p.pos < len(p.s) && strings.IndexByte(chars, p.s[p.pos]) != -1 {
+		p.pos++
+	}
+}
+
+func (p *headerParser) token() string {
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(" \t,=\"", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// value reads a token or a quoted string, unescaping the latter.
+func (p *headerParser) value() string {
+	if !p.consume('"') {
+		return p.token()
+	}
+	var b strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == '"':
+			return b.String()
+		case c == '\\' && p.pos < len(p.s):
+			b.WriteByte(p.s[p.pos])
+			p.pos++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func basicAuthorization(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+// digestAuthorization answers a Digest challenge (RFC 7616) for a request
+// with method and uri, the nc-th to use the challenge's nonce.
+func digestAuthorization(c authChallenge, username, password, method, uri string, nc int, cnonce string) (string, error) {
+	algorithm := c.params["algorithm"]
+	var newHash func() hash.Hash
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5", "MD5-SESS":
+		newHash = md5.New
+	case "SHA-256", "SHA-256-SESS":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm: %s", algorithm)
+	}
+	h := func(s string) string {
+		sum := newHash()
+		sum.Write([]byte(s))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+
+	realm, nonce := c.params["realm"], c.params["nonce"]
+	count := fmt.Sprintf("%08x", nc)
+	ha1 := h(username + ":" + realm + ":" + password)
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+
+	qop := ""
+	if c.params["qop"] != "" {
+		qop = "auth"
+	}
+	var response string
+	if qop == "" {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + count + ":" + cnonce + ":" + qop + ":" + ha2)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%s, realm=%s, nonce=%s, uri=%s`, quote(username), quote(realm), quote(nonce), quote(uri))
+	if algorithm != "" {
+		fmt.Fprintf(&b, ", algorithm=%s", algorithm)
+	}
+	fmt.Fprintf(&b, ", response=%s", quote(response))
+	if qop != "" {
+		fmt.Fprintf(&b, ", qop=%s, nc=%s, cnonce=%s", qop, count, quote(cnonce))
+	}
+	if opaque, ok := c.params["opaque"]; ok {
+		fmt.Fprintf(&b, ", opaque=%s", quote(opaque))
+	}
+	return b.String(), nil
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func randomCnonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,397 @@
+package engine
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseChallenges(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected []authChallenge
+	}{
+		{
+			header:   `Basic realm="Secret Area"`,
+			expected: []authChallenge{{"basic", map[string]string{"realm": "Secret Area"}}},
+		},
+		{
+			header: `Digest realm="a, b", qop="auth,auth-int", nonce=abc, Basic realm=x`,
+			expected: []authChallenge{
+				{"digest", map[string]string{"realm": "a, b", "qop": "auth,auth-int", "nonce": "abc"}},
+				{"basic", map[string]string{"realm": "x"}},
+			},
+		},
+		{
+			header: `Negotiate dG9rZW4=, Basic realm="say \"hi\""`,
+			expected: []authChallenge{
+				{"negotiate", map[string]string{"dg9rzw4": ""}},
+				{"basic", map[string]string{"realm": `say "hi"`}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got := parseChallenges(tt.header)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("for %q, expected %v, got %v", tt.header, tt.expected, got)
+		}
+	}
+}
+
+func TestPickChallenge(t *testing.T) {
+	challenges := parseChallenges(`Basic realm=x, Digest realm=x, nonce=1, qop=auth, Digest realm=x, nonce=2, qop=auth, algorithm=SHA-256, Digest realm=x, nonce=3, qop=auth-int`)
+	best, ok := pickChallenge(challenges)
+	if !ok || best.params["nonce"] != "2" {
+		t.Errorf("expected the SHA-256 digest challenge, got %v", best)
+	}
+	if _, ok := pickChallenge(parseChallenges(`Bearer realm=x`)); ok {
+		t.Errorf("expected no supported challenge for Bearer")
+	}
+}
+
+// TestDigestAuthorization checks the examples of RFC 7616 section 3.9.1.
+func TestDigestAuthorization(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		response  string
+	}{
+		{"MD5", "8ca523f5e9506fed4657c9700eebdbec"},
+		{"SHA-256", "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+	}
+	for _, tt := range tests {
+		challenge := authChallenge{"digest", map[string]string{
+			"realm":     "http-auth@example.org",
+			"qop":       "auth, auth-int",
+			"algorithm": tt.algorithm,
+			"nonce":     "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v",
+			"opaque":    "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS",
+		}}
+		authorization, err := digestAuthorization(challenge, "Mufasa", "Circle of Life", "GET", "/dir/index.html", 1, "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ")
+		if err != nil {
+			t.Fatalf("digest failed: %v", err)
+		}
+		if !strings.Contains(authorization, `response="`+tt.response+`"`) {
+			t.Errorf("for %s, expected response %s in %q", tt.algorithm, tt.response, authorization)
+		}
+		for _, want := range []string{`qop=auth`, `nc=00000001`, `opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`} {
+			if !strings.Contains(authorization, want) {
+				t.Errorf("expected %s in %q", want, authorization)
+			}
+		}
+	}
+}
+
+func TestBasicAuthFromURL(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "alice" || password != "p@ss word" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "welcome")
+	}))
+	defer server.Close()
+
+	url, err := Parse(strings.Replace(server.URL, "http://", "http://alice:p%40ss%20word@", 1) + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	if strings.Contains(url.String(), "alice") {
+		t.Errorf("expected credentials to be left out of %q", url.String())
+	}
+	response, err := NewEngine().Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if string(response.Body) != "welcome" {
+		t.Errorf("expected %q, got %q", "welcome", response.Body)
+	}
+	if attempts != 2 {
+		t.Errorf("expected a challenge and a retry, got %d requests", attempts)
+	}
+}
+
+const (
+	testDigestRealm = "digest@example.org"
+	testDigestNonce = "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+)
+
+// digestHandler challenges requests until they answer with bob:secret for
+// the request-target they were sent with, serving "digest ok" then. It
+// records the Authorization of every request in authorizations.
+func digestHandler(authorizations *[]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*authorizations = append(*authorizations, r.Header.Get("Authorization"))
+		params := parseChallenges(r.Header.Get("Authorization"))
+		if len(params) == 1 && params[0].scheme == "digest" {
+			p := params[0].params
+			h := func(s string) string {
+				sum := md5.Sum([]byte(s))
+				return hex.EncodeToString(sum[:])
+			}
+			ha1 := h("bob:" + testDigestRealm + ":secret")
+			ha2 := h("GET:" + p["uri"])
+			expected := h(ha1 + ":" + testDigestNonce + ":" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+			if p["response"] == expected && p["uri"] == r.RequestURI {
+				fmt.Fprint(w, "digest ok")
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", qop="auth", nonce="%s", opaque="x"`, testDigestRealm, testDigestNonce))
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+}
+
+func TestDigestAuthWithCredentialsFunc(t *testing.T) {
+	var authorizations []string
+	server := httptest.NewServer(digestHandler(&authorizations))
+	defer server.Close()
+
+	var requests []AuthRequest
+	e := NewEngine(WithCredentials(func(req AuthRequest) (string, string, bool) {
+		requests = append(requests, req)
+		if req.Attempt == 1 {
+			return "bob", "wrong", true
+		}
+		return "bob", "secret", true
+	}))
+	url, err := Parse(server.URL + "/private?x=1")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	response, err := e.Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if string(response.Body) != "digest ok" {
+		t.Errorf("expected %q, got %d %q", "digest ok", response.StatusCode, response.Body)
+	}
+	if len(requests) != 2 || requests[0].Realm != testDigestRealm || requests[0].Scheme != "Digest" || requests[1].Attempt != 2 {
+		t.Errorf("expected to be asked twice for realm %q, got %+v", testDigestRealm, requests)
+	}
+}
+
+func TestAuthGivesUp(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("WWW-Authenticate", `Basic realm="never"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	url, err := Parse(server.URL + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+
+	response, err := NewEngine().Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if response.StatusCode != http.StatusUnauthorized || attempts != 1 {
+		t.Errorf("expected the 401 back without credentials, got %d after %d requests", response.StatusCode, attempts)
+	}
+
+	attempts = 0
+	e := NewEngine(WithCredentials(func(AuthRequest) (string, string, bool) {
+		return "user", "wrong", true
+	}))
+	response, err = e.Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if response.StatusCode != http.StatusUnauthorized || attempts != MAX_AUTH_ATTEMPTS+1 {
+		t.Errorf("expected the 401 back after %d retries, got %d after %d requests", MAX_AUTH_ATTEMPTS, response.StatusCode, attempts)
+	}
+}
+
+func TestAuthorizationDroppedOnCrossOriginRedirect(t *testing.T) {
+	var leaked string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get("Authorization")
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+"/", http.StatusFound)
+	}))
+	defer server.Close()
+
+	url, err := Parse(server.URL + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	if _, err := NewEngine().Request(url, map[string]string{"Authorization": "Basic c2VjcmV0"}); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if leaked != "" {
+		t.Errorf("expected Authorization to be dropped, got %q", leaked)
+	}
+}
+
+func TestAuthRemembersProtectionSpace(t *testing.T) {
+	var authorizations []string
+	server := httptest.NewServer(digestHandler(&authorizations))
+	defer server.Close()
+
+	var asked int
+	e := NewEngine(WithCredentials(func(req AuthRequest) (string, string, bool) {
+		asked++
+		return "bob", "secret", true
+	}))
+	tests := []struct {
+		path     string
+		requests int
+		nc       string
+	}{
+		{"/private/a", 2, "00000001"},
+		// Under the same directory, the credentials go up front with the
+		// next nonce count.
+		{"/private/b?x=1", 1, "00000002"},
+		// Outside it, the 401 names a realm the engine has credentials for.
+		{"/other", 2, "00000001"},
+		// The nonce counts on from the highest count sent with it.
+		{"/private/c", 1, "00000003"},
+	}
+	for _, tt := range tests {
+		authorizations = nil
+		url, err := Parse(server.URL + tt.path)
+		if err != nil {
+			t.Fatalf("failed to parse URL: %v", err)
+		}
+		response, err := e.Request(url, nil)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		if string(response.Body) != "digest ok" {
+			t.Errorf("for %s, expected %q, got %d %q", tt.path, "digest ok", response.StatusCode, response.Body)
+		}
+		if len(authorizations) != tt.requests {
+			t.Errorf("for %s, expected %d requests, got %d", tt.path, tt.requests, len(authorizations))
+			continue
+		}
+		last := parseChallenges(authorizations[len(authorizations)-1])
+		if len(last) != 1 || last[0].params["nc"] != tt.nc {
+			t.Errorf("for %s, expected nc=%s, got %v", tt.path, tt.nc, last)
+		}
+	}
+	if asked != 1 {
+		t.Errorf("expected to be asked for credentials once, got %d", asked)
+	}
+}
+
+func TestAuthForgetsRejectedCredentials(t *testing.T) {
+	password := "first"
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if _, got, ok := r.BasicAuth(); ok && got == password {
+			fmt.Fprint(w, "welcome")
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	var asked int
+	e := NewEngine(WithCredentials(func(req AuthRequest) (string, string, bool) {
+		asked++
+		return "alice", password, true
+	}))
+	url, err := Parse(server.URL + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	for _, change := range []string{"", "second"} {
+		if change != "" {
+			password = change
+		}
+		requests = 0
+		response, err := e.Request(url, nil)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		if string(response.Body) != "welcome" {
+			t.Errorf("expected %q, got %d %q", "welcome", response.StatusCode, response.Body)
+		}
+		if requests != 2 {
+			t.Errorf("expected a challenge and a retry, got %d requests", requests)
+		}
+	}
+	if asked != 2 {
+		t.Errorf("expected to be asked again once the password changed, got %d", asked)
+	}
+}
+
+func TestDigestURIThroughProxy(t *testing.T) {
+	var authorizations []string
+	proxy := httptest.NewServer(digestHandler(&authorizations))
+	defer proxy.Close()
+
+	e := NewEngine(
+		WithProxy("http://"+proxy.Listener.Addr().String()),
+		WithCredentials(func(AuthRequest) (string, string, bool) {
+			return "bob", "secret", true
+		}),
+	)
+	url, err := Parse("http://example.invalid/page")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	response, err := e.Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if string(response.Body) != "digest ok" {
+		t.Errorf("expected %q, got %d %q", "digest ok", response.StatusCode, response.Body)
+	}
+	if len(authorizations) != 2 || !strings.Contains(authorizations[1], `uri="http://example.invalid/page"`) {
+		t.Errorf("expected the absolute URL as digest uri, got %q", authorizations)
+	}
+}
+
+func TestAuthLeavesCallerHeadersAlone(t *testing.T) {
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "alice" || password != "pw" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "welcome")
+	}))
+	defer private.Close()
+	var leaked string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get("Authorization")
+	}))
+	defer other.Close()
+
+	e := NewEngine(WithCredentials(func(AuthRequest) (string, string, bool) {
+		return "alice", "pw", true
+	}))
+	headers := map[string]string{"Accept": "text/html"}
+	for _, target := range []string{private.URL + "/a", private.URL + "/b", other.URL + "/"} {
+		url, err := Parse(target)
+		if err != nil {
+			t.Fatalf("failed to parse URL: %v", err)
+		}
+		if _, err := e.Request(url, headers); err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+	}
+	if leaked != "" {
+		t.Errorf("expected no Authorization for another origin, got %q", leaked)
+	}
+	if len(headers) != 1 {
+		t.Errorf("expected the caller's headers to be left alone, got %v", headers)
+	}
+}
@@ -1,10 +1,24 @@
 package engine
 
-import "time"
+import (
+	"container/list"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_CACHE_MAX_BYTES   = 64 << 20
+	DEFAULT_CACHE_MAX_ENTRIES = 1024
+)
 
 type CacheValue[T any] struct {
-	Value     T
-	MaxAge    int64
+	Value  T
+	MaxAge int64
+	// Vary holds the request header values the response was selected by,
+	// keyed by the header names listed in the response's Vary header.
+	Vary      map[string]string
 	expiresAt *time.Time
 }
 
@@ -17,9 +31,292 @@ func NewCacheValue[T any](value T, maxAge int64) *CacheValue[T] {
 	}
 }
 
+func newCacheValueExpiring[T any](value T, maxAge int64, expiresAt time.Time) *CacheValue[T] {
+	return &CacheValue[T]{
+		Value:     value,
+		MaxAge:    maxAge,
+		expiresAt: &expiresAt,
+	}
+}
+
+// ExpiresAt returns when the value goes stale, or the zero time if it
+// never does.
+func (cv *CacheValue[T]) ExpiresAt() time.Time {
+	if cv.expiresAt == nil {
+		return time.Time{}
+	}
+	return *cv.expiresAt
+}
+
 func (cv *CacheValue[T]) IsExpired() bool {
 	if cv.expiresAt == nil {
 		return false
 	}
 	return time.Now().After(*cv.expiresAt)
 }
+
+// Matches reports whether a request with the given headers selects this
+// cached variant.
+func (cv *CacheValue[T]) Matches(headers map[string]string) bool {
+	for name, value := range cv.Vary {
+		requestValue, _ := getHeader(headers, name)
+		if normalizeHeaderValue(requestValue) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// varyValues collects the selecting request header values for a response
+// Vary header. It returns false when the response varies on "*" and so
+// can never be served from cache.
+func varyValues(vary string, headers map[string]string) (map[string]string, bool) {
+	values := make(map[string]string)
+	for _, name := range strings.Split(vary, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if name == "*" {
+			return nil, false
+		}
+		requestValue, _ := getHeader(headers, name)
+		values[strings.ToLower(name)] = normalizeHeaderValue(requestValue)
+	}
+	return values, true
+}
+
+func normalizeHeaderValue(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+func sameVary(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if other, ok := b[name]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// CacheStats reports how a cache has been used since it was created.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Bytes     int64
+	Entries   int
+}
+
+// CacheStorage is where the engine keeps cached responses. Each key may
+// hold several variants, selected by the request headers named in the
+// response's Vary header. Errors report storage that couldn't be read or
+// written; the engine reports them to observers and carries on without
+// the cache.
+type CacheStorage interface {
+	// Lookup returns the fresh variant stored for key that the request
+	// headers select. A response returned along with an error is still
+	// good.
+	Lookup(key string, headers map[string]string) (*Response, bool, error)
+	// Store caches value under key, replacing any variant selected by the
+	// same request header values.
+	Store(key string, value *CacheValue[*Response]) error
+	// Evict removes the variants of key that the request headers select.
+	Evict(key string, headers map[string]string) error
+	Stats() CacheStats
+}
+
+// CacheEntryInfo describes one variant held by a cache.
+type CacheEntryInfo struct {
+	Key        string
+	URL        string
+	StatusCode int
+	Size       int64
+	Vary       map[string]string
+	// ExpiresAt is when the variant goes stale, or the zero time if it
+	// never does.
+	ExpiresAt time.Time
+}
+
+// CacheLister is implemented by CacheStorage that can list what it holds.
+type CacheLister interface {
+	Entries() []CacheEntryInfo
+}
+
+type cacheEntry struct {
+	key   string
+	value *CacheValue[*Response]
+	size  int64
+}
+
+// MemoryCache is an in-memory response cache bounded by total size and
+// number of entries. When either budget is exceeded the least recently
+// used variants are evicted first.
+type MemoryCache struct {
+	mu         sync.Mutex
+	maxBytes   int64
+	maxEntries int
+	lru        *list.List
+	items      map[string][]*list.Element
+	stats      CacheStats
+}
+
+// NewMemoryCache creates a cache holding at most maxBytes of responses
+// and maxEntries variants. A limit of zero or less disables that budget.
+func NewMemoryCache(maxBytes int64, maxEntries int) *MemoryCache {
+	return &MemoryCache{
+		maxBytes:   maxBytes,
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		items:      make(map[string][]*list.Element),
+	}
+}
+
+// WithCacheLimits sets the byte and entry budget of the engine's cache.
+func WithCacheLimits(maxBytes int64, maxEntries int) Option {
+	return func(e *Engine) {
+		e.cache = NewMemoryCache(maxBytes, maxEntries)
+	}
+}
+
+// WithCacheStorage makes the engine keep its cached responses in storage.
+func WithCacheStorage(storage CacheStorage) Option {
+	return func(e *Engine) {
+		e.cache = storage
+	}
+}
+
+// Lookup returns the fresh variant stored for key that matches the request
+// headers, dropping any expired variants it comes across.
+func (c *MemoryCache) Lookup(key string, headers map[string]string) (*Response, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var found *list.Element
+	for _, elem := range slices.Clone(c.items[key]) {
+		entry := elem.Value.(*cacheEntry)
+		if entry.value.IsExpired() {
+			c.remove(elem)
+			continue
+		}
+		if found == nil && entry.value.Matches(headers) {
+			found = elem
+		}
+	}
+	if found == nil {
+		c.stats.Misses++
+		return nil, false, nil
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(found)
+	return found.Value.(*cacheEntry).value.Value, true, nil
+}
+
+// Store caches value under key, replacing any variant selected by the
+// same request header values, and evicts old entries to stay in budget.
+func (c *MemoryCache) Store(key string, value *CacheValue[*Response]) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, elem := range c.items[key] {
+		if sameVary(elem.Value.(*cacheEntry).value.Vary, value.Vary) {
+			c.remove(elem)
+			break
+		}
+	}
+
+	size := responseSize(value.Value)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return nil
+	}
+	elem := c.lru.PushFront(&cacheEntry{key: key, value: value, size: size})
+	c.items[key] = append(c.items[key], elem)
+	c.stats.Bytes += size
+	c.stats.Entries++
+
+	for c.overBudget() {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+	return nil
+}
+
+// Evict removes the variants of key that the request headers select.
+func (c *MemoryCache) Evict(key string, headers map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, elem := range slices.Clone(c.items[key]) {
+		if elem.Value.(*cacheEntry).value.Matches(headers) {
+			c.remove(elem)
+		}
+	}
+	return nil
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *MemoryCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Entries lists the cached variants, most recently used first.
+func (c *MemoryCache) Entries() []CacheEntryInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]CacheEntryInfo, 0, c.lru.Len())
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*cacheEntry)
+		entries = append(entries, CacheEntryInfo{
+			Key:        entry.key,
+			URL:        entry.value.Value.URL,
+			StatusCode: entry.value.Value.StatusCode,
+			Size:       entry.size,
+			Vary:       entry.value.Vary,
+			ExpiresAt:  entry.value.ExpiresAt(),
+		})
+	}
+	return entries
+}
+
+func (c *MemoryCache) overBudget() bool {
+	if c.lru.Len() == 0 {
+		return false
+	}
+	return (c.maxBytes > 0 && c.stats.Bytes > c.maxBytes) ||
+		(c.maxEntries > 0 && c.stats.Entries > c.maxEntries)
+}
+
+func (c *MemoryCache) remove(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	c.stats.Bytes -= entry.size
+	c.stats.Entries--
+
+	variants := c.items[entry.key]
+	for i, other := range variants {
+		if other == elem {
+			variants = append(variants[:i], variants[i+1:]...)
+			break
+		}
+	}
+	if len(variants) == 0 {
+		delete(c.items, entry.key)
+	} else {
+		c.items[entry.key] = variants
+	}
+}
+
+// responseSize approximates the memory held by a cached response.
+func responseSize(r *Response) int64 {
+	size := int64(len(r.URL) + len(r.Body))
+	for k, v := range r.Headers {
+		size += int64(len(k) + len(v))
+	}
+	return size
+}
@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCacheVary(t *testing.T) {
+	var requestCount int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprintf(w, "lang=%s", r.Header.Get("Accept-Language"))
+	}))
+	defer server.Close()
+
+	url, err := Parse(server.URL + "/vary")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+
+	e := NewEngine()
+
+	testCases := []struct {
+		lang          string
+		expected      string
+		expectedCount int
+	}{
+		{"en", "lang=en", 1},
+		{"uk", "lang=uk", 2},
+		{"en", "lang=en", 2}, // served from the cached "en" variant
+		{"uk", "lang=uk", 2}, // served from the cached "uk" variant
+		{"de", "lang=de", 3},
+	}
+
+	for _, tc := range testCases {
+		response, err := e.Request(url, map[string]string{"Accept-Language": tc.lang})
+		if err != nil {
+			t.Fatalf("request with Accept-Language %q failed: %v", tc.lang, err)
+		}
+		if string(response.Body) != tc.expected {
+			t.Errorf("for Accept-Language %q, expected %q, got %q", tc.lang, tc.expected, string(response.Body))
+		}
+		if requestCount != tc.expectedCount {
+			t.Errorf("for Accept-Language %q, expected %d requests, got %d", tc.lang, tc.expectedCount, requestCount)
+		}
+	}
+}
+
+func TestCacheVaryStar(t *testing.T) {
+	var requestCount int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "*")
+		fmt.Fprint(w, "uncacheable")
+	}))
+	defer server.Close()
+
+	url, err := Parse(server.URL + "/star")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+
+	e := NewEngine()
+	for range 2 {
+		if _, err := e.Request(url, nil); err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+	}
+	if requestCount != 2 {
+		t.Errorf("expected 2 requests for Vary: *, got %d", requestCount)
+	}
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	newValue := func(body string) *CacheValue[*Response] {
+		return NewCacheValue(&Response{Body: []byte(body)}, 60)
+	}
+
+	c := NewMemoryCache(0, 2)
+	c.Store("a", newValue("a"))
+	c.Store("b", newValue("b"))
+	if _, ok, _ := c.Lookup("a", nil); !ok { // "a" is now the most recently used
+		t.Fatalf("expected a to be cached")
+	}
+	c.Store("c", newValue("c"))
+
+	if _, ok, _ := c.Lookup("b", nil); ok {
+		t.Errorf("expected least recently used entry b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok, _ := c.Lookup(key, nil); !ok {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+
+	stats := c.Stats()
+	expected := CacheStats{Hits: 3, Misses: 1, Evictions: 1, Bytes: 2, Entries: 2}
+	if stats != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, stats)
+	}
+
+	c = NewMemoryCache(10, 0)
+	c.Store("small", newValue("12345"))
+	c.Store("big", newValue("12345678901"))
+	if _, ok, _ := c.Lookup("big", nil); ok {
+		t.Errorf("expected entry larger than the byte budget not to be cached")
+	}
+	c.Store("other", newValue("123456"))
+	if _, ok, _ := c.Lookup("small", nil); ok {
+		t.Errorf("expected small to be evicted to stay within the byte budget")
+	}
+	if stats := c.Stats(); stats.Bytes != 6 || stats.Entries != 1 {
+		t.Errorf("expected 6 bytes in 1 entry, got %d bytes in %d entries", stats.Bytes, stats.Entries)
+	}
+}
+
+func TestCachedResponseNotShared(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "<p>cached</p>")
+	}))
+	defer server.Close()
+
+	e := NewEngine()
+	urls := []string{server.URL + "/", "view-source:" + server.URL + "/", server.URL + "/"}
+	var responses []*Response
+	for _, rawURL := range urls {
+		url, err := Parse(rawURL)
+		if err != nil {
+			t.Fatalf("failed to parse URL: %v", err)
+		}
+		response, err := e.Request(url, nil)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		if response.Reason != "OK" {
+			t.Errorf("for load %d of %s, expected reason %q, got %q", len(responses), rawURL, "OK", response.Reason)
+		}
+		// Callers may change what they get back.
+		response.Reason = "changed"
+		responses = append(responses, response)
+	}
+	// Each load gets a response of its own, whatever came after it.
+	for i, response := range responses {
+		expected := strings.HasPrefix(urls[i], "view-source:")
+		if response.ViewSource != expected {
+			t.Errorf("for load %d of %s, expected ViewSource %v, got %v", i, urls[i], expected, response.ViewSource)
+		}
+	}
+	if stats := e.CacheStats(); stats.Hits != 2 {
+		t.Errorf("expected 2 cache hits, got %d", stats.Hits)
+	}
+}
@@ -0,0 +1,264 @@
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"maps"
+	"net"
+	neturl "net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// connKey identifies the pooled connection a request to url can reuse.
+// Plain http requests through an HTTP proxy share the connection to the
+// proxy, while tunnels are specific to their target.
+func connKey(url *URL, proxyURL *neturl.URL) string {
+	if proxyURL == nil {
+		return url.hostWithPort()
+	}
+	proxyHost := proxyHostWithPort(proxyURL)
+	if forwardsHTTP(url, proxyURL) {
+		return "proxy " + proxyHost
+	}
+	return "proxy " + proxyHost + " " + url.hostWithPort()
+}
+
+// connect returns a connection for a request to url, reusing a pooled one
+// when possible. Connections that negotiated HTTP/2 come with the client
+// connection multiplexing requests over them.
+func (e *Engine) connect(url *URL, proxyURL *neturl.URL, trace *requestTrace) (io.ReadWriteCloser, *http2ClientConn, bool, error) {
+	key := connKey(url, proxyURL)
+
+	e.mu.Lock()
+	for {
+		if cc, ok := e.h2Conns[key]; ok {
+			if cc.canTakeNewRequest() {
+				e.mu.Unlock()
+				trace.connected(cc.conn, proxyURL, true)
+				return cc.conn, cc, true, nil
+			}
+			delete(e.h2Conns, key)
+		}
+		// A pooled HTTP/1.1 connection carries one request at a time, so it
+		// leaves the pool until the response has been read.
+		if existing, ok := e.connMap[key]; ok {
+			delete(e.connMap, key)
+			e.mu.Unlock()
+			trace.connected(*existing, proxyURL, true)
+			return *existing, nil, true, nil
+		}
+		// An https connection being set up may turn out to be HTTP/2, so
+		// wait for it rather than opening another one.
+		dialing, ok := e.dialing[key]
+		if !ok {
+			break
+		}
+		e.mu.Unlock()
+		<-dialing
+		e.mu.Lock()
+	}
+	if url.scheme == "https" {
+		dialing := make(chan struct{})
+		e.dialing[key] = dialing
+		defer func() {
+			e.mu.Lock()
+			delete(e.dialing, key)
+			e.mu.Unlock()
+			close(dialing)
+		}()
+	}
+	e.mu.Unlock()
+
+	conn, err := e.dial(url, proxyURL, trace)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if info := tlsInfo(conn, e.tlsConfig); info == nil || info.NegotiatedProtocol != "h2" {
+		return conn, nil, false, nil
+	}
+
+	cc, err := newHTTP2ClientConn(conn, &e.limits, func(cc *http2ClientConn) {
+		e.mu.Lock()
+		if e.h2Conns[key] == cc {
+			delete(e.h2Conns, key)
+		}
+		e.mu.Unlock()
+	})
+	if err != nil {
+		return nil, nil, false, err
+	}
+	e.mu.Lock()
+	// The connection may already be gone, having been refused right away.
+	if cc.canTakeNewRequest() {
+		e.h2Conns[key] = cc
+	}
+	e.mu.Unlock()
+	return conn, cc, false, nil
+}
+
+// dial opens a connection for an http or https request to url, going
+// through proxyURL when it isn't nil, and records how long each step took.
+func (e *Engine) dial(url *URL, proxyURL *neturl.URL, trace *requestTrace) (io.ReadWriteCloser, error) {
+	hostWithPort := url.hostWithPort()
+	address := hostWithPort
+	if proxyURL != nil {
+		address = proxyHostWithPort(proxyURL)
+	}
+
+	timings := trace.timings
+	start := time.Now()
+	conn, err := e.dialHost(context.Background(), address, trace)
+	if err != nil {
+		return nil, err
+	}
+
+	if proxyURL != nil && !forwardsHTTP(url, proxyURL) {
+		if proxyURL.Scheme == "http" {
+			err = connectTunnel(conn, hostWithPort, proxyURL)
+		} else {
+			err = e.socksConnect(conn, hostWithPort, proxyURL, trace)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	timings.Connect = time.Since(start) - timings.DNS
+	trace.connected(conn, proxyURL, false)
+	if url.scheme == "http" {
+		return conn, nil
+	}
+
+	start = time.Now()
+	tlsConn, err := tlsClient(conn, hostWithPort, e.tlsConfig)
+	timings.TLS = time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	trace.emit(TLSHandshakeEvent{URL: trace.url, TLS: tlsInfo(tlsConn, e.tlsConfig), Duration: timings.TLS})
+	return tlsConn, nil
+}
+
+// forwardsHTTP reports whether a request to url is forwarded by an HTTP
+// proxy rather than sent through a tunnel.
+func forwardsHTTP(url *URL, proxyURL *neturl.URL) bool {
+	return proxyURL != nil && proxyURL.Scheme == "http" && url.scheme == "http"
+}
+
+// tlsClient runs a TLS handshake for hostWithPort over an established conn.
+func tlsClient(conn net.Conn, hostWithPort string, config *tls.Config) (*tls.Conn, error) {
+	config = config.Clone()
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(hostWithPort)
+		if err != nil {
+			host = hostWithPort
+		}
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, newError(ErrTLS, err)
+	}
+	return tlsConn, nil
+}
+
+func proxyHostWithPort(proxyURL *neturl.URL) string {
+	if proxyURL.Port() != "" {
+		return proxyURL.Host
+	}
+	if proxyURL.Scheme == "http" {
+		return net.JoinHostPort(proxyURL.Hostname(), "80")
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), "1080")
+}
+
+// ConnectionInfo describes the connection a response arrived on.
+type ConnectionInfo struct {
+	// RemoteAddr is the address of the peer the engine is connected to,
+	// which is the proxy when one is used.
+	RemoteAddr string
+	// Proxy is the proxy the request went through, if any.
+	Proxy string
+	// Reused is true when the connection came from the keep-alive pool.
+	Reused bool
+}
+
+// PooledConnection describes an open connection the engine keeps for later
+// requests.
+type PooledConnection struct {
+	// Key is what requests reusing the connection are matched by: the
+	// host and port, or the proxy it goes through.
+	Key        string
+	RemoteAddr string
+	// Proto is "HTTP/1.1" for idle keep-alive connections and "HTTP/2.0"
+	// for multiplexed ones.
+	Proto string
+	// ActiveStreams counts the requests in flight on an HTTP/2
+	// connection.
+	ActiveStreams int
+}
+
+// PooledConnections lists the connections the engine holds open, sorted
+// by key.
+func (e *Engine) PooledConnections() []PooledConnection {
+	e.mu.Lock()
+	var conns []PooledConnection
+	for key, conn := range e.connMap {
+		conns = append(conns, PooledConnection{
+			Key:        key,
+			RemoteAddr: connectionInfo(*conn, nil, false).RemoteAddr,
+			Proto:      "HTTP/1.1",
+		})
+	}
+	h2Conns := maps.Clone(e.h2Conns)
+	e.mu.Unlock()
+
+	for key, cc := range h2Conns {
+		cc.mu.Lock()
+		active := len(cc.streams)
+		cc.mu.Unlock()
+		conns = append(conns, PooledConnection{
+			Key:           key,
+			RemoteAddr:    connectionInfo(cc.conn, nil, false).RemoteAddr,
+			Proto:         "HTTP/2.0",
+			ActiveStreams: active,
+		})
+	}
+	slices.SortFunc(conns, func(a, b PooledConnection) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return conns
+}
+
+// connected emits the ConnectedEvent for conn.
+func (t *requestTrace) connected(conn io.ReadWriteCloser, proxyURL *neturl.URL, reused bool) {
+	info := connectionInfo(conn, proxyURL, reused)
+	event := ConnectedEvent{
+		URL:        t.url,
+		RemoteAddr: info.RemoteAddr,
+		Proxy:      info.Proxy,
+		Reused:     reused,
+	}
+	if !reused {
+		event.Duration = t.timings.Connect
+	}
+	t.emit(event)
+}
+
+func connectionInfo(conn io.ReadWriteCloser, proxyURL *neturl.URL, reused bool) *ConnectionInfo {
+	info := &ConnectionInfo{Reused: reused}
+	if netConn, ok := conn.(net.Conn); ok {
+		info.RemoteAddr = netConn.RemoteAddr().String()
+	}
+	if proxyURL != nil {
+		info.Proxy = proxyURL.Redacted()
+	}
+	return info
+}
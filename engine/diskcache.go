@@ -0,0 +1,509 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	diskCacheIndexFile = "index.json"
+	diskCacheLockFile  = "index.lock"
+)
+
+// DISK_CACHE_USAGE_SAVE_INTERVAL is how often lookups alone rewrite the
+// index to persist when entries were last used. Stores, evictions and
+// Close write them along with everything else.
+const DISK_CACHE_USAGE_SAVE_INTERVAL = 30 * time.Second
+
+type diskCacheEntry struct {
+	Key        string            `json:"key"`
+	URL        string            `json:"url"`
+	StatusCode int               `json:"status_code"`
+	Headers    map[string]string `json:"headers"`
+	MaxAge     int64             `json:"max_age"`
+	Vary       map[string]string `json:"vary,omitempty"`
+	ExpiresAt  time.Time         `json:"expires_at"`
+	BodyHash   string            `json:"body_hash"`
+	Size       int64             `json:"size"`
+	LastUsed   time.Time         `json:"last_used"`
+}
+
+// usageKey identifies entry across reloads of the index.
+func (entry *diskCacheEntry) usageKey() string {
+	return entry.Key + "\x00" + entry.BodyHash
+}
+
+// DiskCache is a CacheStorage that survives restarts. Bodies are stored
+// once per distinct content under bodies/<sha256>, and the metadata for
+// every variant lives in a JSON index that is rewritten atomically.
+//
+// Several processes can share the directory: each operation holds an
+// advisory lock on it while it reads the index, applies its change and
+// writes the index back, so no process overwrites what another one
+// stored. On systems without flock only the atomic writes remain.
+type DiskCache struct {
+	mu         sync.Mutex
+	dir        string
+	maxBytes   int64
+	maxEntries int
+	lockFile   *os.File
+	// index is the index file as last read or written, to notice when
+	// another process replaces it.
+	index   os.FileInfo
+	entries map[string][]*diskCacheEntry
+	stats   CacheStats
+	// used holds the LastUsed times of lookups not saved yet, by
+	// usageKey, and saved when the index was last written.
+	used  map[string]time.Time
+	saved time.Time
+}
+
+// OpenDiskCache opens or creates a disk cache in dir with the same budget
+// semantics as NewMemoryCache. A corrupt index is set aside and the cache
+// starts empty instead of failing.
+func OpenDiskCache(dir string, maxBytes int64, maxEntries int) (*DiskCache, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "bodies"), 0o755); err != nil {
+		return nil, err
+	}
+	lockFile, err := os.OpenFile(filepath.Join(dir, diskCacheLockFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	c := &DiskCache{
+		dir:        dir,
+		maxBytes:   maxBytes,
+		maxEntries: maxEntries,
+		lockFile:   lockFile,
+		entries:    make(map[string][]*diskCacheEntry),
+		saved:      time.Now(),
+	}
+	if err := c.lock(); err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+	defer c.unlock()
+
+	c.removeOrphanBodies()
+	return c, nil
+}
+
+// Close saves when entries were last used and releases the cache
+// directory. The cache can't be used afterwards.
+func (c *DiskCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	if len(c.used) > 0 {
+		if err = c.lock(); err == nil {
+			err = c.save()
+			c.unlock()
+		}
+	}
+	return errors.Join(err, c.lockFile.Close())
+}
+
+// Lookup returns the fresh variant stored for key that the request headers
+// select, and records that it was used so eviction stays least recently
+// used across restarts. The use is only written out with the next change
+// to the index, or once DISK_CACHE_USAGE_SAVE_INTERVAL has passed. An
+// error means the index couldn't be read or updated; a response returned
+// along with it is still good.
+func (c *DiskCache) Lookup(key string, headers map[string]string) (*Response, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.lock(); err != nil {
+		c.stats.Misses++
+		return nil, false, err
+	}
+	defer c.unlock()
+
+	changed := false
+	var found *Response
+	for _, entry := range slices.Clone(c.entries[key]) {
+		value := entry.cacheValue(nil)
+		if value.IsExpired() {
+			c.remove(entry)
+			changed = true
+			continue
+		}
+		if found != nil || !value.Matches(headers) {
+			continue
+		}
+		body, err := c.readBody(entry.BodyHash)
+		if err != nil {
+			// The body went missing or was corrupted on disk, so the
+			// entry can't be trusted anymore.
+			c.remove(entry)
+			changed = true
+			continue
+		}
+		entry.LastUsed = time.Now()
+		if c.used == nil {
+			c.used = make(map[string]time.Time)
+		}
+		c.used[entry.usageKey()] = entry.LastUsed
+		found = entry.cacheValue(body).Value
+	}
+	if found == nil {
+		c.stats.Misses++
+	} else {
+		c.stats.Hits++
+	}
+	var err error
+	if changed || (len(c.used) > 0 && time.Since(c.saved) >= DISK_CACHE_USAGE_SAVE_INTERVAL) {
+		err = c.save()
+	}
+	return found, found != nil, err
+}
+
+func (c *DiskCache) Store(key string, value *CacheValue[*Response]) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	for _, entry := range c.entries[key] {
+		if sameVary(entry.Vary, value.Vary) {
+			c.remove(entry)
+			break
+		}
+	}
+
+	r := value.Value
+	size := responseSize(r)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return c.save()
+	}
+	hash, err := c.writeBody(r.Body)
+	if err != nil {
+		return errors.Join(err, c.save())
+	}
+	entry := &diskCacheEntry{
+		Key:        key,
+		URL:        r.URL,
+		StatusCode: r.StatusCode,
+		Headers:    r.Headers,
+		MaxAge:     value.MaxAge,
+		Vary:       value.Vary,
+		ExpiresAt:  value.ExpiresAt(),
+		BodyHash:   hash,
+		Size:       size,
+		LastUsed:   time.Now(),
+	}
+	c.entries[key] = append(c.entries[key], entry)
+	c.stats.Bytes += size
+	c.stats.Entries++
+
+	c.evictOverBudget()
+	return c.save()
+}
+
+func (c *DiskCache) Evict(key string, headers map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	changed := false
+	for _, entry := range slices.Clone(c.entries[key]) {
+		if entry.cacheValue(nil).Matches(headers) {
+			c.remove(entry)
+			changed = true
+		}
+	}
+	if changed {
+		return c.save()
+	}
+	return nil
+}
+
+func (c *DiskCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Entries lists the cached variants, most recently used first.
+func (c *DiskCache) Entries() []CacheEntryInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Without the lock the entries this process last saw are listed.
+	if err := c.lock(); err == nil {
+		defer c.unlock()
+	}
+
+	var all []*diskCacheEntry
+	for _, variants := range c.entries {
+		all = append(all, variants...)
+	}
+	slices.SortFunc(all, func(a, b *diskCacheEntry) int {
+		return b.LastUsed.Compare(a.LastUsed)
+	})
+	entries := make([]CacheEntryInfo, 0, len(all))
+	for _, entry := range all {
+		entries = append(entries, CacheEntryInfo{
+			Key:        entry.Key,
+			URL:        entry.URL,
+			StatusCode: entry.StatusCode,
+			Size:       entry.Size,
+			Vary:       entry.Vary,
+			ExpiresAt:  entry.ExpiresAt,
+		})
+	}
+	return entries
+}
+
+func (c *DiskCache) evictOverBudget() {
+	overBudget := func() bool {
+		return (c.maxBytes > 0 && c.stats.Bytes > c.maxBytes) ||
+			(c.maxEntries > 0 && c.stats.Entries > c.maxEntries)
+	}
+	if !overBudget() {
+		return
+	}
+
+	var all []*diskCacheEntry
+	for _, variants := range c.entries {
+		all = append(all, variants...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].LastUsed.Before(all[j].LastUsed)
+	})
+	for _, entry := range all {
+		if !overBudget() {
+			break
+		}
+		c.remove(entry)
+		c.stats.Evictions++
+	}
+}
+
+// remove drops entry from the index and deletes its body once no other
+// entry refers to it.
+func (c *DiskCache) remove(entry *diskCacheEntry) {
+	variants := c.entries[entry.Key]
+	for i, other := range variants {
+		if other == entry {
+			variants = append(variants[:i], variants[i+1:]...)
+			c.stats.Bytes -= entry.Size
+			c.stats.Entries--
+			break
+		}
+	}
+	if len(variants) == 0 {
+		delete(c.entries, entry.Key)
+	} else {
+		c.entries[entry.Key] = variants
+	}
+
+	for _, variants := range c.entries {
+		for _, other := range variants {
+			if other.BodyHash == entry.BodyHash {
+				return
+			}
+		}
+	}
+	os.Remove(c.bodyPath(entry.BodyHash))
+}
+
+// lock takes the lock on the cache directory that all processes using it
+// share, and reloads the index if another process replaced it since this
+// one last read or wrote it. Changes made while holding the lock have to
+// be saved before unlock.
+func (c *DiskCache) lock() error {
+	if err := lockFile(c.lockFile); err != nil {
+		return fmt.Errorf("failed to lock disk cache: %w", err)
+	}
+	if err := c.reload(); err != nil {
+		unlockFile(c.lockFile)
+		return err
+	}
+	return nil
+}
+
+func (c *DiskCache) unlock() {
+	unlockFile(c.lockFile)
+}
+
+// reload reads the index into memory unless it is the one already there.
+func (c *DiskCache) reload() error {
+	info, err := os.Stat(c.indexPath())
+	if errors.Is(err, os.ErrNotExist) {
+		info = nil
+	} else if err != nil {
+		return err
+	}
+	if sameIndex(info, c.index) {
+		return nil
+	}
+
+	var list []*diskCacheEntry
+	if info != nil {
+		data, err := os.ReadFile(c.indexPath())
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &list); err != nil {
+			os.Rename(c.indexPath(), c.indexPath()+".corrupt")
+			list, info = nil, nil
+		}
+	}
+	c.entries = make(map[string][]*diskCacheEntry)
+	c.stats.Bytes, c.stats.Entries = 0, 0
+	for _, entry := range list {
+		if entry == nil || entry.Key == "" || !c.bodyExists(entry.BodyHash) {
+			continue
+		}
+		// Uses not saved yet outlive the index another process wrote.
+		if used, ok := c.used[entry.usageKey()]; ok && used.After(entry.LastUsed) {
+			entry.LastUsed = used
+		}
+		c.entries[entry.Key] = append(c.entries[entry.Key], entry)
+		c.stats.Bytes += entry.Size
+		c.stats.Entries++
+	}
+	c.index = info
+	return nil
+}
+
+// sameIndex reports whether a and b describe the same version of the
+// index file. Every save replaces the file, so a new version is a new
+// file.
+func sameIndex(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+// save writes the index to a temporary file and renames it into place so
+// that readers never observe a partially written index.
+func (c *DiskCache) save() error {
+	list := make([]*diskCacheEntry, 0, c.stats.Entries)
+	for _, variants := range c.entries {
+		list = append(list, variants...)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.indexPath(), data); err != nil {
+		return err
+	}
+	info, err := os.Stat(c.indexPath())
+	if err != nil {
+		return err
+	}
+	c.index = info
+	c.used = nil
+	c.saved = time.Now()
+	return nil
+}
+
+func (c *DiskCache) writeBody(body []byte) (string, error) {
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	if c.bodyExists(hash) {
+		return hash, nil
+	}
+	if err := writeFileAtomic(c.bodyPath(hash), body); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+func (c *DiskCache) readBody(hash string) ([]byte, error) {
+	body, err := os.ReadFile(c.bodyPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != hash {
+		os.Remove(c.bodyPath(hash))
+		return nil, fmt.Errorf("corrupt cache body %s", hash)
+	}
+	return body, nil
+}
+
+func (c *DiskCache) removeOrphanBodies() {
+	referenced := make(map[string]bool)
+	for _, variants := range c.entries {
+		for _, entry := range variants {
+			referenced[entry.BodyHash] = true
+		}
+	}
+	files, err := os.ReadDir(filepath.Join(c.dir, "bodies"))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		// Bodies are written under the lock, so any other process has
+		// indexed its bodies by now, but a temporary file may belong to
+		// one that doesn't lock.
+		if !referenced[file.Name()] && !strings.HasPrefix(file.Name(), ".tmp-") {
+			os.Remove(filepath.Join(c.dir, "bodies", file.Name()))
+		}
+	}
+}
+
+func (c *DiskCache) bodyExists(hash string) bool {
+	if hash == "" {
+		return false
+	}
+	_, err := os.Stat(c.bodyPath(hash))
+	return err == nil
+}
+
+func (c *DiskCache) indexPath() string {
+	return filepath.Join(c.dir, diskCacheIndexFile)
+}
+
+func (c *DiskCache) bodyPath(hash string) string {
+	return filepath.Join(c.dir, "bodies", hash)
+}
+
+func (entry *diskCacheEntry) cacheValue(body []byte) *CacheValue[*Response] {
+	value := newCacheValueExpiring(&Response{
+		URL:        entry.URL,
+		StatusCode: entry.StatusCode,
+		Headers:    entry.Headers,
+		Body:       body,
+	}, entry.MaxAge, entry.ExpiresAt)
+	value.Vary = entry.Vary
+	return value
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
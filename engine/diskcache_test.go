@@ -0,0 +1,250 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiskCachePersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := OpenDiskCache(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open disk cache: %v", err)
+	}
+	value := NewCacheValue(&Response{
+		URL:        "http://example.com/",
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "text/html"},
+		Body:       []byte("<p>cached</p>"),
+	}, 60)
+	value.Vary = map[string]string{"accept-language": "en"}
+	if err := c.Store("http://example.com/", value); err != nil {
+		t.Fatalf("failed to store: %v", err)
+	}
+
+	c, err = OpenDiskCache(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to reopen disk cache: %v", err)
+	}
+	if _, ok, _ := c.Lookup("http://example.com/", map[string]string{"Accept-Language": "uk"}); ok {
+		t.Errorf("expected no variant for Accept-Language uk")
+	}
+	r, ok, err := c.Lookup("http://example.com/", map[string]string{"Accept-Language": "en"})
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if !ok {
+		t.Fatalf("expected cached response after reopening")
+	}
+	if string(r.Body) != "<p>cached</p>" || r.StatusCode != 200 || r.Headers["Content-Type"] != "text/html" {
+		t.Errorf("unexpected cached response %+v", r)
+	}
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDiskCacheCorruption(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := OpenDiskCache(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open disk cache: %v", err)
+	}
+	if err := c.Store("a", NewCacheValue(&Response{Body: []byte("a")}, 60)); err != nil {
+		t.Fatalf("failed to store: %v", err)
+	}
+
+	bodies, err := os.ReadDir(filepath.Join(dir, "bodies"))
+	if err != nil || len(bodies) != 1 {
+		t.Fatalf("expected one stored body, got %v (%v)", bodies, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bodies", bodies[0].Name()), []byte("tampered"), 0o644); err != nil {
+		t.Fatalf("failed to tamper with body: %v", err)
+	}
+	if _, ok, _ := c.Lookup("a", nil); ok {
+		t.Errorf("expected corrupted body to be treated as a miss")
+	}
+	if stats := c.Stats(); stats.Entries != 0 {
+		t.Errorf("expected corrupted entry to be dropped, got %d entries", stats.Entries)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, diskCacheIndexFile), []byte("{not json"), 0o644); err != nil {
+		t.Fatalf("failed to corrupt index: %v", err)
+	}
+	c, err = OpenDiskCache(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("expected corrupt index to be recovered from, got %v", err)
+	}
+	c.Store("b", NewCacheValue(&Response{Body: []byte("b")}, 60))
+	if _, ok, _ := c.Lookup("b", nil); !ok {
+		t.Errorf("expected recovered cache to be usable")
+	}
+}
+
+func TestDiskCacheSharedDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	// Each cache stands in for a separate process sharing the directory.
+	var caches []*DiskCache
+	for range 2 {
+		c, err := OpenDiskCache(dir, 0, 0)
+		if err != nil {
+			t.Fatalf("failed to open disk cache: %v", err)
+		}
+		defer c.Close()
+		caches = append(caches, c)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i, c := range caches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 20 {
+				key := fmt.Sprintf("http://example.com/%d/%d", i, j)
+				errs <- c.Store(key, NewCacheValue(&Response{URL: key, Body: []byte(key)}, 60))
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("failed to store: %v", err)
+		}
+	}
+
+	// Neither cache overwrote what the other stored.
+	for i, c := range caches {
+		other := fmt.Sprintf("http://example.com/%d/0", 1-i)
+		if _, ok, err := c.Lookup(other, nil); !ok || err != nil {
+			t.Errorf("expected cache %d to see %s, got %v (%v)", i, other, ok, err)
+		}
+	}
+	reopened, err := OpenDiskCache(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to reopen disk cache: %v", err)
+	}
+	defer reopened.Close()
+	if entries := reopened.Stats().Entries; entries != 40 {
+		t.Errorf("expected 40 entries, got %d", entries)
+	}
+}
+
+func TestDiskCachePersistsUsage(t *testing.T) {
+	dir := t.TempDir()
+	c, err := OpenDiskCache(dir, 0, 2)
+	if err != nil {
+		t.Fatalf("failed to open disk cache: %v", err)
+	}
+	c.Store("a", NewCacheValue(&Response{Body: []byte("a")}, 60))
+	c.Store("b", NewCacheValue(&Response{Body: []byte("b")}, 60))
+	if _, ok, err := c.Lookup("a", nil); !ok || err != nil {
+		t.Fatalf("expected a to be cached, got %v (%v)", ok, err)
+	}
+	c.Close()
+
+	c, err = OpenDiskCache(dir, 0, 2)
+	if err != nil {
+		t.Fatalf("failed to reopen disk cache: %v", err)
+	}
+	defer c.Close()
+	c.Store("c", NewCacheValue(&Response{Body: []byte("c")}, 60))
+	if _, ok, _ := c.Lookup("b", nil); ok {
+		t.Errorf("expected b, the least recently used before the restart, to be evicted")
+	}
+	if _, ok, _ := c.Lookup("a", nil); !ok {
+		t.Errorf("expected a to survive eviction")
+	}
+}
+
+func TestDiskCacheLookupsBatchUsage(t *testing.T) {
+	dir := t.TempDir()
+	c, err := OpenDiskCache(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open disk cache: %v", err)
+	}
+	defer c.Close()
+	for _, key := range []string{"a", "b", "c"} {
+		if err := c.Store(key, NewCacheValue(&Response{Body: []byte(key)}, 60)); err != nil {
+			t.Fatalf("failed to store %s: %v", key, err)
+		}
+	}
+	indexPath := filepath.Join(dir, diskCacheIndexFile)
+	before, err := os.Stat(indexPath)
+	if err != nil {
+		t.Fatalf("failed to stat index: %v", err)
+	}
+	for range 10 {
+		for _, key := range []string{"a", "b", "c"} {
+			if _, ok, err := c.Lookup(key, nil); !ok || err != nil {
+				t.Fatalf("expected %s to be cached, got %v (%v)", key, ok, err)
+			}
+		}
+	}
+	after, err := os.Stat(indexPath)
+	if err != nil {
+		t.Fatalf("failed to stat index: %v", err)
+	}
+	if !sameIndex(before, after) {
+		t.Errorf("expected lookups not to rewrite the index")
+	}
+
+	// Once the interval has passed, the next lookup saves the uses.
+	c.saved = time.Now().Add(-DISK_CACHE_USAGE_SAVE_INTERVAL)
+	if _, _, err := c.Lookup("a", nil); err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if after, err = os.Stat(indexPath); err != nil || sameIndex(before, after) {
+		t.Errorf("expected the index to be rewritten after the interval, got %v", err)
+	}
+}
+
+func TestDiskCacheWriteErrors(t *testing.T) {
+	dir := t.TempDir()
+	c, err := OpenDiskCache(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open disk cache: %v", err)
+	}
+	defer c.Close()
+
+	// Bodies can't be written once their directory is a file.
+	if err := os.RemoveAll(filepath.Join(dir, "bodies")); err != nil {
+		t.Fatalf("failed to remove bodies: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bodies"), nil, 0o644); err != nil {
+		t.Fatalf("failed to replace bodies: %v", err)
+	}
+	if err := c.Store("a", NewCacheValue(&Response{Body: []byte("a")}, 60)); err == nil {
+		t.Errorf("expected an error storing a body")
+	}
+
+	recorder := &eventRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "cacheable")
+	}))
+	defer server.Close()
+	url, err := Parse(server.URL + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	if _, err := NewEngine(WithCacheStorage(c), WithObserver(recorder)).Request(url, nil); err != nil {
+		t.Fatalf("expected the request to succeed without the cache, got %v", err)
+	}
+	for _, event := range recorder.events {
+		if _, ok := event.(CacheErrorEvent); ok {
+			return
+		}
+	}
+	t.Errorf("expected a CacheErrorEvent")
+}
@@ -0,0 +1,219 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// downloadState is kept next to a partial download so a later Download can
+// check that the server still has the same representation before resuming.
+type downloadState struct {
+	URL       string `json:"url"`
+	Validator string `json:"validator"`
+}
+
+// download writes one response body into a partial file, picking up from
+// what earlier attempts left there.
+type download struct {
+	url       *URL
+	partPath  string
+	statePath string
+	file      *os.File
+	// offset is how many bytes of the representation the partial file
+	// holds.
+	offset int64
+	// skip is how many bytes of the current body are already in the file.
+	skip int64
+	// written counts the bytes the current attempt added to the file.
+	written int64
+	// size is the length of the whole representation, or -1 if unknown.
+	size int64
+}
+
+// Download fetches url into the file at path. The body goes to
+// path+".part" as it arrives, along with the response's validator, and is
+// moved to path once complete. If a connection drops, the download carries
+// on with a Range request for the missing bytes, as often as the retry
+// policy allows; a partial file left by an earlier call is resumed the same
+// way. If-Range makes the server send the whole body again if it changed
+// in between.
+//
+// The returned response describes the last exchange and has no body.
+func (e *Engine) Download(url *URL, path string) (*Response, error) {
+	d := &download{url: url, partPath: path + ".part", statePath: path + ".part.json"}
+	defer d.close()
+
+	for attempt := 1; ; attempt++ {
+		before := d.resumeOffset()
+		headers := map[string]string{"Accept-Encoding": "identity"}
+		if before > 0 {
+			state, _ := d.loadState()
+			headers["Range"] = RangeHeader(ByteRange{Start: before, End: -1})
+			headers["If-Range"] = state.Validator
+		}
+		d.size, d.written = -1, 0
+		r, err := e.request(url, headers, d.sink)
+		d.close()
+
+		// Only attempts that got somewhere are worth repeating; anything
+		// else is up to the retry policy of the request itself.
+		progressed := d.written > 0
+		if err == nil {
+			switch {
+			case r.StatusCode == 416 && before > 0:
+				// Either the file was already complete or the resource
+				// shrank; in the second case the download starts over.
+				value, _ := getHeader(r.Headers, "Content-Range")
+				if cr, perr := ParseContentRange(value); perr == nil && cr.Size == before {
+					return r, d.complete(path)
+				}
+				d.discard()
+				err = fmt.Errorf("%s: range not satisfiable", url)
+				progressed = true
+			case r.StatusCode != 200 && r.StatusCode != 206:
+				return nil, fmt.Errorf("%s: download failed with status %d", url, r.StatusCode)
+			case d.size < 0 || d.offset >= d.size:
+				return r, d.complete(path)
+			default:
+				err = requestError(url, ErrProtocol, fmt.Errorf("body ended after %d of %d bytes", d.offset, d.size))
+			}
+		}
+
+		if !progressed || attempt >= e.retry.MaxAttempts {
+			return nil, err
+		}
+		delay := e.retry.backoff(attempt)
+		e.emit(RetryEvent{URL: url.String(), Attempt: attempt + 1, Delay: delay, Err: err})
+		time.Sleep(delay)
+	}
+}
+
+// resumeOffset returns how much of the download the partial file holds,
+// or 0 if it can't be resumed and has to start over.
+func (d *download) resumeOffset() int64 {
+	state, err := d.loadState()
+	if err != nil || state.URL != d.url.String() || state.Validator == "" {
+		d.discard()
+		return 0
+	}
+	info, err := os.Stat(d.partPath)
+	if err != nil {
+		d.discard()
+		return 0
+	}
+	d.offset = info.Size()
+	return d.offset
+}
+
+// sink opens the partial file for a 200 or 206 response and leaves any
+// other response in memory. A retried request may get here again for the
+// same download, so each response starts from where the file ends.
+func (d *download) sink(r *Response) (io.Writer, error) {
+	d.close()
+	var start int64
+	switch r.StatusCode {
+	case 200:
+		d.offset = 0
+		if cl, ok := getHeader(r.Headers, "Content-Length"); ok {
+			fmt.Sscan(cl, &d.size)
+		}
+	case 206:
+		value, _ := getHeader(r.Headers, "Content-Range")
+		cr, err := ParseContentRange(value)
+		if err != nil {
+			return nil, err
+		}
+		if cr.Start < 0 || cr.Start > d.offset {
+			return nil, fmt.Errorf("server sent %q, but the download stopped at byte %d", value, d.offset)
+		}
+		start, d.size = cr.Start, cr.Size
+	default:
+		return nil, nil
+	}
+
+	file, err := os.OpenFile(d.partPath, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(d.offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(d.offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	d.file = file
+	d.skip = d.offset - start
+
+	// A new body needs its validator saved before any of it lands in the
+	// file. Without one a later attempt couldn't tell whether the rest
+	// still belongs to the same representation, and starts over.
+	if r.StatusCode == 200 {
+		state := downloadState{URL: d.url.String()}
+		state.Validator, _ = IfRangeValidator(r)
+		data, err := json.Marshal(state)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(d.statePath, data); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Write appends the bytes of the current body the file doesn't have yet.
+func (d *download) Write(p []byte) (int, error) {
+	total := len(p)
+	if d.skip > 0 {
+		n := min(d.skip, int64(len(p)))
+		d.skip -= n
+		p = p[n:]
+	}
+	n, err := d.file.Write(p)
+	d.offset += int64(n)
+	d.written += int64(n)
+	if err != nil {
+		return total - len(p) + n, err
+	}
+	return total, nil
+}
+
+func (d *download) loadState() (downloadState, error) {
+	var state downloadState
+	data, err := os.ReadFile(d.statePath)
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, err
+	}
+	return state, nil
+}
+
+func (d *download) close() {
+	if d.file != nil {
+		d.file.Close()
+		d.file = nil
+	}
+}
+
+// complete moves the finished download to path.
+func (d *download) complete(path string) error {
+	if err := os.Rename(d.partPath, path); err != nil {
+		return err
+	}
+	os.Remove(d.statePath)
+	return nil
+}
+
+// discard throws away a partial download that can't be resumed.
+func (d *download) discard() {
+	os.Remove(d.partPath)
+	os.Remove(d.statePath)
+	d.offset = 0
+}
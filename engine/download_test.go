@@ -0,0 +1,165 @@
+package engine
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDownload(t *testing.T) {
+	content := strings.Repeat("0123456789", 10)
+	state := func(validator string) string {
+		return `{"url":"http://example.com/file.bin","validator":"` + strings.ReplaceAll(validator, `"`, `\"`) + `"}`
+	}
+	tests := []struct {
+		name string
+		// part and partState are left over from an earlier download.
+		part, partState string
+		// truncate is how many responses get cut off after 30 bytes.
+		truncate int
+		status   int
+		ranges   []string
+		wantErr  bool
+		// remaining is what's left in the partial file after an error.
+		remaining string
+	}{
+		{
+			name:   "Fresh download",
+			ranges: []string{""},
+		},
+		{
+			name:     "Resume after a dropped connection",
+			truncate: 1,
+			ranges:   []string{"", `bytes=30- "v1"`},
+		},
+		{
+			name:      "Resume an earlier download",
+			part:      content[:30],
+			partState: state(`"v1"`),
+			ranges:    []string{`bytes=30- "v1"`},
+		},
+		{
+			name:      "Changed since the earlier download",
+			part:      "garbage",
+			partState: state(`"v0"`),
+			ranges:    []string{`bytes=7- "v0"`},
+		},
+		{
+			name:      "Earlier download without a validator",
+			part:      "garbage",
+			partState: state(""),
+			ranges:    []string{""},
+		},
+		{
+			name:      "Earlier download was complete",
+			part:      content,
+			partState: state(`"v1"`),
+			ranges:    []string{`bytes=100- "v1"`},
+		},
+		{
+			name:      "Attempts run out",
+			truncate:  3,
+			ranges:    []string{"", `bytes=30- "v1"`, `bytes=60- "v1"`},
+			wantErr:   true,
+			remaining: content[:90],
+		},
+		{
+			name:    "Not found",
+			status:  404,
+			ranges:  []string{""},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var ranges []string
+			transport := newPipeTransport(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				ranges = append(ranges, strings.TrimSpace(r.Header.Get("Range")+" "+r.Header.Get("If-Range")))
+				truncate := len(ranges) <= tt.truncate
+				mu.Unlock()
+
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.Header().Set("ETag", `"v1"`)
+				if truncate {
+					// Send 30 bytes of the body, then drop the connection.
+					rw := &truncatingWriter{ResponseWriter: w, left: 30}
+					http.ServeContent(rw, r, "file.bin", time.Time{}, strings.NewReader(content))
+					panic(http.ErrAbortHandler)
+				}
+				http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader(content))
+			}))
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "file.bin")
+			if tt.part != "" {
+				os.WriteFile(path+".part", []byte(tt.part), 0o644)
+				os.WriteFile(path+".part.json", []byte(tt.partState), 0o644)
+			}
+			policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+			e := NewEngine(
+				WithTransport(transport),
+				WithHosts(map[string]string{"example.com": "192.0.2.1"}),
+				WithRetryPolicy(policy),
+			)
+
+			url, err := Parse("http://example.com/file.bin")
+			if err != nil {
+				t.Fatalf("failed to parse URL: %v", err)
+			}
+			_, err = e.Download(url, path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if strings.Join(ranges, "|") != strings.Join(tt.ranges, "|") {
+				t.Errorf("expected requests %q, got %q", tt.ranges, ranges)
+			}
+
+			if tt.wantErr {
+				if _, err := os.Stat(path); err == nil {
+					t.Errorf("expected no file at %s", path)
+				}
+				part, _ := os.ReadFile(path + ".part")
+				if string(part) != tt.remaining {
+					t.Errorf("expected %q left to resume, got %q", tt.remaining, part)
+				}
+				return
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read download: %v", err)
+			}
+			if string(data) != content {
+				t.Errorf("expected %q, got %q", content, data)
+			}
+			for _, leftover := range []string{path + ".part", path + ".part.json"} {
+				if _, err := os.Stat(leftover); err == nil {
+					t.Errorf("expected %s to be removed", leftover)
+				}
+			}
+		})
+	}
+}
+
+// truncatingWriter passes on only the first left bytes of a body.
+type truncatingWriter struct {
+	http.ResponseWriter
+	left int
+}
+
+func (w *truncatingWriter) Write(p []byte) (int, error) {
+	n := min(w.left, len(p))
+	w.left -= n
+	w.ResponseWriter.Write(p[:n])
+	w.ResponseWriter.(http.Flusher).Flush()
+	return len(p), nil
+}
@@ -0,0 +1,234 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"mime"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MAX_DOWNLOAD_NAME_ATTEMPTS caps the numbered names tried when a
+// download's file name is taken, as in "report (1).pdf".
+const MAX_DOWNLOAD_NAME_ATTEMPTS = 100
+
+// DOWNLOAD_PROGRESS_INTERVAL is how often DownloadProgressEvents are
+// emitted while a body arrives.
+const DOWNLOAD_PROGRESS_INTERVAL = 100 * time.Millisecond
+
+// DownloadInfo describes a response body the engine saved to disk instead
+// of keeping it in memory.
+type DownloadInfo struct {
+	Path string
+	Size int64
+	// SHA256 is the hex-encoded SHA-256 digest of the file.
+	SHA256 string
+}
+
+// WithDownloadDir makes the engine save responses that can't be displayed
+// to files in dir, which is created when needed. Their bodies are
+// streamed to disk as they arrive and the returned Response carries a
+// Download describing the file instead of a Body.
+func WithDownloadDir(dir string) Option {
+	return func(e *Engine) {
+		e.downloadDir = dir
+	}
+}
+
+// IsDownload reports whether r is meant to be saved rather than shown: the
+// server asked for it to be an attachment, or it has a type that isn't
+// text.
+func IsDownload(r *Response) bool {
+	if r.StatusCode != 200 {
+		return false
+	}
+	if disposition, ok := getHeader(r.Headers, "Content-Disposition"); ok {
+		kind, _, _ := strings.Cut(disposition, ";")
+		if strings.EqualFold(strings.TrimSpace(kind), "attachment") {
+			return true
+		}
+	}
+	contentType, ok := getHeader(r.Headers, "Content-Type")
+	if !ok {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return !isDisplayable(mediaType)
+}
+
+// isDisplayable reports whether mediaType is text the browser can show.
+func isDisplayable(mediaType string) bool {
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		strings.HasSuffix(mediaType, "+json") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/ecmascript":
+		return true
+	}
+	return false
+}
+
+// DownloadFilename picks the name to save r under: the one the server gave
+// in Content-Disposition, preferring the RFC 5987 filename* form, or else
+// the last segment of the URL path. Directories and characters that can't
+// be in a file name are stripped.
+func DownloadFilename(r *Response) string {
+	if disposition, ok := getHeader(r.Headers, "Content-Disposition"); ok {
+		// ParseMediaType decodes filename* and prefers it over filename.
+		if _, params, err := mime.ParseMediaType(disposition); err == nil && params["filename"] != "" {
+			if name := sanitizeFilename(params["filename"]); name != "" {
+				return name
+			}
+		}
+	}
+	if u, err := neturl.Parse(r.URL); err == nil {
+		if name := sanitizeFilename(u.Path); name != "" {
+			return name
+		}
+	}
+	return "download"
+}
+
+// sanitizeFilename keeps the last element of name and makes it safe to
+// create on any common file system.
+func sanitizeFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	// Leading dots would hide the file and trailing ones are dropped by
+	// Windows anyway.
+	return strings.Trim(name, " .")
+}
+
+// createUnique creates a new file in dir named name, or the first free
+// "name (n).ext" if that is taken.
+func createUnique(dir, name string) (*os.File, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := range MAX_DOWNLOAD_NAME_ATTEMPTS {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		file, err := os.OpenFile(filepath.Join(dir, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		return file, err
+	}
+	return nil, fmt.Errorf("no free name for %s in %s", name, dir)
+}
+
+// fileDownload saves the body of a response to Request into the download
+// directory.
+type fileDownload struct {
+	dir  string
+	emit func(Event)
+
+	url      string
+	file     *os.File
+	hash     hash.Hash
+	received int64
+	total    int64
+	reported time.Time
+}
+
+// sink streams downloads to a new file and leaves anything else in memory.
+func (d *fileDownload) sink(r *Response) (io.Writer, error) {
+	if !IsDownload(r) {
+		return nil, nil
+	}
+	// An earlier response to the same request may have been cut off.
+	d.discard()
+	if err := os.MkdirAll(d.dir, 0o755); err != nil {
+		return nil, err
+	}
+	file, err := createUnique(d.dir, DownloadFilename(r))
+	if err != nil {
+		return nil, err
+	}
+	d.url, d.file, d.hash = r.URL, file, sha256.New()
+	d.received, d.total = 0, -1
+	// The length of an encoded body isn't what ends up on disk.
+	encoding, encoded := getHeader(r.Headers, "Content-Encoding")
+	encoded = encoded && !strings.EqualFold(strings.TrimSpace(encoding), "identity")
+	if cl, ok := getHeader(r.Headers, "Content-Length"); ok && !encoded {
+		if total, err := strconv.ParseInt(strings.TrimSpace(cl), 10, 64); err == nil {
+			d.total = total
+		}
+	}
+	d.progress(false)
+	return d, nil
+}
+
+func (d *fileDownload) Write(p []byte) (int, error) {
+	n, err := d.file.Write(p)
+	d.hash.Write(p[:n])
+	d.received += int64(n)
+	d.progress(false)
+	return n, err
+}
+
+// progress emits a DownloadProgressEvent if enough time has passed since
+// the last one. The final one, once done, is always emitted.
+func (d *fileDownload) progress(done bool) {
+	now := time.Now()
+	if !done && now.Sub(d.reported) < DOWNLOAD_PROGRESS_INTERVAL {
+		return
+	}
+	d.reported = now
+	d.emit(DownloadProgressEvent{URL: d.url, Path: d.file.Name(), Received: d.received, Total: d.total, Done: done})
+}
+
+// finish attaches the saved file to r once the request is done, or
+// removes what was written if it failed.
+func (d *fileDownload) finish(r *Response, err error) (*Response, error) {
+	if d.file == nil {
+		return r, err
+	}
+	closeErr := d.file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(d.file.Name())
+		d.file = nil
+		return nil, err
+	}
+	d.progress(true)
+	r.Download = &DownloadInfo{
+		Path:   d.file.Name(),
+		Size:   d.received,
+		SHA256: hex.EncodeToString(d.hash.Sum(nil)),
+	}
+	d.file = nil
+	return r, nil
+}
+
+func (d *fileDownload) discard() {
+	if d.file != nil {
+		d.file.Close()
+		os.Remove(d.file.Name())
+		d.file = nil
+	}
+}
@@ -0,0 +1,226 @@
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestIsDownload(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		headers  map[string]string
+		expected bool
+	}{
+		{"HTML", 200, map[string]string{"Content-Type": "text/html; charset=utf-8"}, false},
+		{"JSON", 200, map[string]string{"Content-Type": "application/json"}, false},
+		{"SVG", 200, map[string]string{"Content-Type": "image/svg+xml"}, false},
+		{"No type", 200, map[string]string{}, false},
+		{"Zip", 200, map[string]string{"Content-Type": "application/zip"}, true},
+		{"PDF", 200, map[string]string{"Content-Type": "application/pdf"}, true},
+		{"Octet stream", 200, map[string]string{"Content-Type": "application/octet-stream"}, true},
+		{"Attachment", 200, map[string]string{"Content-Type": "text/csv", "Content-Disposition": `Attachment; filename="data.csv"`}, true},
+		{"Inline", 200, map[string]string{"Content-Type": "text/plain", "Content-Disposition": "inline"}, false},
+		{"Error page", 404, map[string]string{"Content-Type": "application/octet-stream"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsDownload(&Response{StatusCode: tt.status, Headers: tt.headers}); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestDownloadFilename(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		disposition string
+		expected    string
+	}{
+		{"filename", "http://example.com/get?id=1", `attachment; filename="report.pdf"`, "report.pdf"},
+		{"filename*", "http://example.com/get", `attachment; filename="EUR rates.txt"; filename*=UTF-8''%e2%82%ac%20rates.txt`, "€ rates.txt"},
+		{"filename* alone", "http://example.com/get", `attachment; filename*=utf-8''na%C3%AFve.txt`, "naïve.txt"},
+		{"Directories", "http://example.com/get", `attachment; filename="../../etc/passwd"`, "passwd"},
+		{"Windows directories", "http://example.com/get", `attachment; filename="C:\\temp\\x.exe"`, "x.exe"},
+		{"Hidden file", "http://example.com/get", `attachment; filename=".bashrc"`, "bashrc"},
+		{"Reserved characters", "http://example.com/get", `attachment; filename="a:b?.txt"`, "a_b_.txt"},
+		{"URL path", "http://example.com/files/archive.tar.gz", "", "archive.tar.gz"},
+		{"Escaped URL path", "http://example.com/files/my%20file.zip", "", "my file.zip"},
+		{"Invalid disposition", "http://example.com/files/a.zip", `attachment; filename=a b.zip`, "a.zip"},
+		{"Nothing to go on", "http://example.com/", "attachment", "download"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Response{URL: tt.url, Headers: map[string]string{}}
+			if tt.disposition != "" {
+				r.Headers["Content-Disposition"] = tt.disposition
+			}
+			if got := DownloadFilename(r); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestDownloadDir(t *testing.T) {
+	report := strings.Repeat("%PDF-1.7 binary data ", 1000)
+	transport := newPipeTransport(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/report":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="report.pdf"`)
+			w.Write([]byte(report))
+		case "/broken.zip":
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Length", "1000")
+			w.Write([]byte("PK"))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>hi</html>"))
+		}
+	}))
+	dir := filepath.Join(t.TempDir(), "downloads")
+	recorder := &eventRecorder{}
+	e := NewEngine(
+		WithTransport(transport),
+		WithHosts(map[string]string{"example.com": "192.0.2.1"}),
+		WithDownloadDir(dir),
+		WithObserver(recorder),
+	)
+	request := func(path string) (*Response, error) {
+		url, err := Parse("http://example.com" + path)
+		if err != nil {
+			t.Fatalf("failed to parse URL: %v", err)
+		}
+		return e.Request(url, nil)
+	}
+
+	sum := sha256.Sum256([]byte(report))
+	for _, name := range []string{"report.pdf", "report (1).pdf"} {
+		response, err := request("/report")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		if response.Download == nil {
+			t.Fatalf("expected a download, got body %q", response.Body)
+		}
+		if len(response.Body) != 0 {
+			t.Errorf("expected no body in memory, got %d bytes", len(response.Body))
+		}
+		expected := DownloadInfo{Path: filepath.Join(dir, name), Size: int64(len(report)), SHA256: hex.EncodeToString(sum[:])}
+		if *response.Download != expected {
+			t.Errorf("expected %+v, got %+v", expected, *response.Download)
+		}
+		data, err := os.ReadFile(expected.Path)
+		if err != nil || string(data) != report {
+			t.Errorf("expected the report in %s, got %d bytes, %v", expected.Path, len(data), err)
+		}
+	}
+
+	var last DownloadProgressEvent
+	for _, event := range recorder.events {
+		if progress, ok := event.(DownloadProgressEvent); ok {
+			last = progress
+		}
+	}
+	if !last.Done || last.Received != int64(len(report)) || last.Path != filepath.Join(dir, "report (1).pdf") {
+		t.Errorf("expected a final progress event for the second download, got %+v", last)
+	}
+
+	response, err := request("/page")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if response.Download != nil || string(response.Body) != "<html>hi</html>" {
+		t.Errorf("expected the page in memory, got %+v", response)
+	}
+
+	if _, err := request("/broken.zip"); err == nil {
+		t.Errorf("expected an error for a download cut short")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "broken.zip")); err == nil {
+		t.Errorf("expected the incomplete download to be removed")
+	}
+}
+
+func TestDownloadGzip(t *testing.T) {
+	data := strings.Repeat("id,name\n1,alice\n", 1000)
+	gzipped := func(s string) []byte {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write([]byte(s))
+		zw.Close()
+		return b.Bytes()
+	}
+	bodies := map[string][]byte{
+		"/data.csv":   gzipped(data),
+		"/broken.csv": gzipped(data)[:100],
+		"/bomb.csv":   gzipped(strings.Repeat("0", 4<<20)),
+	}
+	transport := newPipeTransport(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := bodies[r.URL.Path]
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+	}))
+	dir := t.TempDir()
+	recorder := &eventRecorder{}
+	e := NewEngine(
+		WithTransport(transport),
+		WithHosts(map[string]string{"example.com": "192.0.2.1"}),
+		WithDownloadDir(dir),
+		WithObserver(recorder),
+	)
+	request := func(path string) (*Response, error) {
+		url, err := Parse("http://example.com" + path)
+		if err != nil {
+			t.Fatalf("failed to parse URL: %v", err)
+		}
+		return e.Request(url, nil)
+	}
+
+	response, err := request("/data.csv")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if response.Download == nil {
+		t.Fatalf("expected a download, got body %q", response.Body)
+	}
+	sum := sha256.Sum256([]byte(data))
+	expected := DownloadInfo{Path: filepath.Join(dir, "data.csv"), Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+	if *response.Download != expected {
+		t.Errorf("expected %+v, got %+v", expected, *response.Download)
+	}
+	if saved, err := os.ReadFile(expected.Path); err != nil || string(saved) != data {
+		t.Errorf("expected the decoded data in %s, got %d bytes, %v", expected.Path, len(saved), err)
+	}
+	for _, event := range recorder.events {
+		if progress, ok := event.(DownloadProgressEvent); ok && progress.Total != -1 {
+			t.Errorf("expected an unknown total for an encoded body, got %+v", progress)
+		}
+	}
+
+	for _, path := range []string{"/broken.csv", "/bomb.csv"} {
+		if _, err := request(path); !errors.Is(err, ErrDecode) {
+			t.Errorf("for %s, expected ErrDecode, got %v", path, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, strings.TrimPrefix(path, "/"))); err == nil {
+			t.Errorf("for %s, expected the download to be removed", path)
+		}
+	}
+}
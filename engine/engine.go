@@ -4,38 +4,160 @@ import (
 	"bytes"
 	"compress/gzip"
 	"crypto/tls"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"maps"
 	"net"
-	"os"
+	neturl "net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const MAX_REDIRECTS = 3
 
-var allowedSchemes = []string{"http", "https", "file", "data"}
-
 type Engine struct {
-	connMap map[string]*io.ReadWriteCloser
-	cache   map[string]*CacheValue[*Response]
+	mu        sync.Mutex // guards connMap, h2Conns and dialing
+	connMap   map[string]*io.ReadWriteCloser
+	h2Conns   map[string]*http2ClientConn
+	dialing   map[string]chan struct{}
+	cache     CacheStorage
+	proxy     ProxyFunc
+	tlsConfig *tls.Config
+	hsts      *HSTSStore
+	transport Transport
+	recorder  *Archive
+	replay    *Archive
+	har       *HAR
+	observers []Observer
+	resolver  Resolver
+	hosts     map[string]string
+	// resolveNames is set when the engine resolves host names before
+	// handing them to its transport. See resolvesHost.
+	resolveNames bool
+
+	happyEyeballsDelay time.Duration
+	credentials        CredentialsFunc
+	authMu             sync.Mutex // guards authSpaces
+	authSpaces         map[string]*authSpace
+	retry              RetryPolicy
+	limits             Limits
+	downloadDir        string
+	conditions         *NetworkConditions
 }
 
-func NewEngine() *Engine {
-	return &Engine{
-		connMap: make(map[string]*io.ReadWriteCloser),
-		cache:   make(map[string]*CacheValue[*Response]),
+// Option configures an Engine created by NewEngine.
+type Option func(*Engine)
+
+func NewEngine(opts ...Option) *Engine {
+	e := &Engine{
+		connMap:   make(map[string]*io.ReadWriteCloser),
+		h2Conns:   make(map[string]*http2ClientConn),
+		dialing:   make(map[string]chan struct{}),
+		cache:     NewMemoryCache(DEFAULT_CACHE_MAX_BYTES, DEFAULT_CACHE_MAX_ENTRIES),
+		proxy:     ProxyFromEnvironment,
+		tlsConfig: &tls.Config{},
+		hsts:      NewHSTSStore(),
+		transport: &net.Dialer{},
+		resolver:  NewCachingResolver(&SystemResolver{}),
+		hosts:     make(map[string]string),
+
+		happyEyeballsDelay: DEFAULT_HAPPY_EYEBALLS_DELAY,
+		retry:              DEFAULT_RETRY_POLICY,
+		limits:             DEFAULT_LIMITS,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if _, ok := e.transport.(*net.Dialer); ok {
+		e.resolveNames = true
+	}
+	if e.conditions != nil {
+		e.transport = Throttle(e.transport, *e.conditions)
+		e.resolver = &throttledResolver{resolver: e.resolver, latency: e.conditions.Latency}
 	}
+	return e
+}
+
+// Close closes the connections the engine keeps open for reuse, failing
+// any HTTP/2 requests still in flight on them. Later requests open new
+// connections.
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	conns, h2Conns := e.connMap, e.h2Conns
+	e.connMap = make(map[string]*io.ReadWriteCloser)
+	e.h2Conns = make(map[string]*http2ClientConn)
+	e.mu.Unlock()
+
+	var errs []error
+	for _, conn := range conns {
+		errs = append(errs, (*conn).Close())
+	}
+	for _, cc := range h2Conns {
+		cc.fail(net.ErrClosed)
+	}
+	return errors.Join(errs...)
+}
+
+// CacheStats returns usage counters for the engine's response cache.
+func (e *Engine) CacheStats() CacheStats {
+	return e.cache.Stats()
+}
+
+// CacheEntries lists what the engine's response cache holds, or nil if its
+// storage can't be listed.
+func (e *Engine) CacheEntries() []CacheEntryInfo {
+	if lister, ok := e.cache.(CacheLister); ok {
+		return lister.Entries()
+	}
+	return nil
 }
 
 type Response struct {
 	URL        string
 	StatusCode int
+	// Proto is the protocol the response arrived over, e.g. "HTTP/1.1".
+	Proto string
+	// Reason is the reason phrase from an HTTP/1.x status line.
+	Reason     string
 	Headers    map[string]string
 	Body       []byte
 	ViewSource bool
+	// TLS describes the negotiated connection for https responses.
+	TLS *TLSInfo
+	// Connection describes the connection http and https responses
+	// arrived on.
+	Connection *ConnectionInfo
+	// Timings breaks down how long the request took on the network. It is
+	// nil for responses that didn't come from the network.
+	Timings *Timings
+	// Download describes the file the body was saved to, for responses
+	// that can't be displayed. See WithDownloadDir.
+	Download *DownloadInfo
+	// streamed is set when the body went to a sink instead of Body.
+	streamed bool
+}
+
+// Timings are the phases of a network request, in the order they happen.
+// Phases that were skipped, such as DNS and connecting on a reused
+// connection, are zero.
+type Timings struct {
+	Start   time.Time
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	Send    time.Duration
+	// Wait lasts from sending the request until the first byte of the
+	// response arrives.
+	Wait    time.Duration
+	Receive time.Duration
+}
+
+// Total is the time the whole request took.
+func (t *Timings) Total() time.Duration {
+	return t.DNS + t.Connect + t.TLS + t.Send + t.Wait + t.Receive
 }
 
 // urlUnescape decodes URL-encoded string
@@ -79,74 +201,119 @@ func urlUnescape(s string) (string, error) {
 }
 
 func (e *Engine) Request(url *URL, headers map[string]string) (*Response, error) {
-	if cacheValue, ok := e.cache[url.String()]; ok {
-		if cacheValue.IsExpired() {
-			delete(e.cache, url.String())
-		} else {
-			return cacheValue.Value, nil
-		}
+	if e.downloadDir == "" {
+		return e.request(url, headers, nil)
 	}
-	delete(e.cache, url.String())
+	d := &fileDownload{dir: e.downloadDir, emit: e.emit}
+	r, err := e.request(url, headers, d.sink)
+	return d.finish(r, err)
+}
 
-	hostWithPort := url.host
-	if !strings.Contains(hostWithPort, ":") {
-		hostWithPort = fmt.Sprintf("%s:%s", url.host, url.port)
+// request is Request with the body of the final response written to
+// whatever sink picks for it. Streamed responses aren't cached.
+func (e *Engine) request(url *URL, headers map[string]string, sink BodySink) (*Response, error) {
+	if url.scheme == "http" && e.hsts.ShouldUpgrade(url.hostname()) {
+		url = upgradeToHTTPS(url)
 	}
 
-	var conn io.ReadWriteCloser
-	var err error
-	if existingConn, ok := e.connMap[hostWithPort]; ok {
-		conn = *existingConn
-	} else {
-		switch url.scheme {
-		case "http":
-			conn, err = net.Dial("tcp", hostWithPort)
-		case "https":
-			conn, err = tls.Dial("tcp", hostWithPort, &tls.Config{})
-		case "file":
-			bytes, err := os.ReadFile(url.path)
-			if err != nil {
-				return nil, err
-			}
-			return &Response{
-				Headers: make(map[string]string),
-				Body:    bytes,
-			}, nil
-		case "data":
-			log.Println("data URL detected", url.path)
-			commaIndex := strings.Index(url.path, ",")
-			if commaIndex == -1 {
-				return nil, fmt.Errorf("invalid data URL")
-			}
-			meta := url.path[:commaIndex]
-			data := url.path[commaIndex+1:]
-			isBase64 := strings.Contains(meta, ";base64")
-			if isBase64 {
-				decoded, err := base64.StdEncoding.DecodeString(data)
-				if err != nil {
-					return nil, err
-				}
-				return &Response{
-					Headers: make(map[string]string),
-					Body:    decoded,
-				}, nil
+	e.emit(RequestStartEvent{URL: url.String()})
+
+	handler, ok := lookupScheme(url.scheme)
+	if !ok {
+		return nil, fmt.Errorf("unsupported scheme: %s", url.scheme)
+	}
+	if streaming, ok := handler.(StreamingSchemeHandler); ok {
+		return e.loadStream(streaming, url, headers, sink)
+	}
+	r, err := handler.Load(e, url, headers)
+	if err != nil {
+		return nil, err
+	}
+	// Handlers may hand out responses they keep, such as cached ones, so
+	// the fields below are set on a copy.
+	cp := *r
+	r = &cp
+	if r.URL == "" {
+		r.URL = url.String()
+	}
+	r.ViewSource = url.ViewSource
+	if err := streamBody(url, r, sink); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// loadStream loads url through a handler that can stream, handing it
+// sink, or a sink that keeps every body in memory if there is none.
+func (e *Engine) loadStream(handler StreamingSchemeHandler, url *URL, headers map[string]string, sink BodySink) (*Response, error) {
+	asked, streamed := false, false
+	r, err := handler.LoadStream(e, url, headers, func(r *Response) (io.Writer, error) {
+		asked = true
+		if sink == nil {
+			return nil, nil
+		}
+		if r.URL == "" {
+			r.URL = url.String()
+		}
+		w, err := sink(r)
+		streamed = w != nil
+		return w, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	cp := *r
+	r = &cp
+	if r.URL == "" {
+		r.URL = url.String()
+	}
+	r.ViewSource = url.ViewSource
+	switch {
+	case streamed:
+		r.streamed = true
+	case !asked:
+		// The handler never offered the body to the sink.
+		if err := streamBody(url, r, sink); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// fetch requests an http or https URL over the network, following
+// redirects and authentication challenges and caching what it may.
+func (e *Engine) fetch(url *URL, headers map[string]string, sink BodySink) (*Response, error) {
+	// The cache only holds whole bodies, so it can't answer for part of
+	// one.
+	if _, isRange := getHeader(headers, "Range"); !isRange {
+		cached, ok, err := e.cache.Lookup(url.String(), headers)
+		e.cacheError(url, err)
+		e.emit(CacheLookupEvent{URL: url.String(), Hit: ok})
+		if ok {
+			if e.har != nil {
+				e.har.add(url, headers, cached, e.cacheKind())
 			}
-			unescaped, err := urlUnescape(data)
-			if err != nil {
+			// The entry is shared with later lookups, so it is never handed
+			// out to be changed.
+			r := *cached
+			if err := streamBody(url, &r, sink); err != nil {
 				return nil, err
 			}
-			return &Response{
-				Headers: make(map[string]string),
-				Body:    []byte(unescaped),
-			}, nil
-		default:
-			return nil, fmt.Errorf("unsupported scheme: %s", url.scheme)
+			return &r, nil
 		}
-		if err != nil {
-			return nil, err
+	}
+
+	var proxyURL *neturl.URL
+	var err error
+	if e.proxy != nil {
+		if proxyURL, err = e.proxy(url); err != nil {
+			return nil, requestError(url, ErrConnect, err)
 		}
 	}
 
+	// The caller may reuse headers for other requests, so what the engine
+	// adds goes into a copy.
+	headers = maps.Clone(headers)
 	if headers == nil {
 		headers = make(map[string]string)
 	}
@@ -156,125 +323,107 @@ func (e *Engine) Request(url *URL, headers map[string]string) (*Response, error)
 		headers["Connection"] = "close"
 	}
 
-	req := fmt.Sprintf("GET %s HTTP/1.1\r\n", url.path)
-	for k, v := range headers {
-		req += fmt.Sprintf("%s: %s\r\n", k, v)
-	}
-	req += "\r\n"
-	if _, err := conn.Write([]byte(req)); err != nil {
-		return nil, err
+	if _, ok := getHeader(headers, "Authorization"); !ok && url.auth == nil {
+		if grant, ok := e.rememberedAuth(url); ok {
+			authorization, err := grant.authorization(requestTarget(url, proxyURL))
+			if err == nil {
+				u := *url
+				u.auth = grant
+				url = &u
+				headers["Authorization"] = authorization
+			}
+		}
 	}
 
-	var responseBuf bytes.Buffer
-	buf := make([]byte, 1024)
-	for {
-		n, err := conn.Read(buf)
-		if err == io.EOF || n == 0 {
-			break
+	var r *Response
+	if e.replay != nil {
+		if r, err = e.replay.replay(url); err != nil {
+			return nil, requestError(url, ErrConnect, err)
+		}
+		decoder := &gunzipSink{sink: sink, limits: &e.limits}
+		err = streamBody(url, r, decoder.bodySink())
+		if decodeErr := decoder.finish(err); decodeErr != nil {
+			return nil, requestError(url, ErrDecode, decodeErr)
+		}
+		if err != nil {
+			return nil, requestError(url, ErrConnect, err)
+		}
+	} else {
+		decoder := &gunzipSink{sink: sink, limits: &e.limits}
+		r, err = e.roundTripWithRetries(url, proxyURL, headers, decoder.bodySink())
+		if decodeErr := decoder.finish(err); decodeErr != nil {
+			return nil, requestError(url, ErrDecode, decodeErr)
 		}
 		if err != nil {
 			return nil, err
 		}
-		responseBuf.Write(buf[:n])
-	}
-
-	responseData := responseBuf.Bytes()
-
-	// Find the end of headers
-	headerEnd := bytes.Index(responseData, []byte("\r\n\r\n"))
-	if headerEnd == -1 {
-		return nil, fmt.Errorf("invalid HTTP response: no header end")
-	}
-
-	headerData := responseData[:headerEnd]
-	bodyData := responseData[headerEnd+4:]
-
-	headerLines := strings.Split(string(headerData), "\r\n")
-	if len(headerLines) == 0 {
-		return nil, fmt.Errorf("invalid HTTP response: no status line")
-	}
-
-	statusLine := headerLines[0]
-	if !strings.HasPrefix(statusLine, "HTTP/1.1") {
-		return nil, fmt.Errorf("unsupported HTTP version")
+		if e.recorder != nil {
+			e.recorder.record(url, headers, r)
+		}
 	}
-	statusParts := strings.Split(statusLine, " ")
-	if len(statusParts) < 2 {
-		return nil, fmt.Errorf("invalid status line")
+	var harEntry *HAREntry
+	if e.har != nil {
+		harEntry = e.har.add(url, headers, r, "")
 	}
 
-	statusCode, err := strconv.Atoi(statusParts[1])
-	if err != nil {
-		return nil, fmt.Errorf("invalid status code: %s", statusParts[1])
+	// Policies only count when they arrive over a verified https connection.
+	if sts, ok := r.Headers["Strict-Transport-Security"]; ok && url.scheme == "https" && !e.tlsConfig.InsecureSkipVerify {
+		e.hsts.Record(url.hostname(), sts)
 	}
 
-	respHeaders := make(map[string]string)
-	for _, line := range headerLines[1:] {
-		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 {
-			respHeaders[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	if r.StatusCode == 401 {
+		if retryURL, retryHeaders, ok := e.authenticate(url, proxyURL, headers, r); ok {
+			return e.request(retryURL, retryHeaders, sink)
 		}
+	} else if url.auth != nil {
+		e.rememberAuth(url, url.auth)
 	}
 
-	if statusCode >= 300 && statusCode < 400 {
-		if location, ok := respHeaders["Location"]; ok {
+	if r.StatusCode >= 300 && r.StatusCode < 400 {
+		if location, ok := r.Headers["Location"]; ok {
 			if strings.HasPrefix(location, "/") {
 				location = fmt.Sprintf("%s://%s%s", url.scheme, url.host, location)
 			}
 			newURL, err := Parse(location)
 			if err != nil {
-				return nil, err
+				return nil, requestError(url, ErrProtocol, fmt.Errorf("invalid Location %q: %v", location, err))
 			}
 			newURL.redirectCount = url.redirectCount + 1
 			if newURL.redirectCount > MAX_REDIRECTS {
-				return nil, fmt.Errorf("maximum redirects exceeded")
+				return nil, requestError(url, ErrTooManyRedirects, nil)
 			}
-			return e.Request(newURL, headers)
-		}
-	}
-
-	if clStr, ok := respHeaders["Content-Length"]; ok {
-		if cl, err := strconv.Atoi(clStr); err == nil && len(bodyData) > cl {
-			bodyData = bodyData[:cl]
-		}
-	}
-
-	if connectionHeader, ok := respHeaders["Connection"]; ok {
-		if strings.ToLower(connectionHeader) == "close" {
-			conn.Close()
-			delete(e.connMap, hostWithPort)
-		} else if strings.ToLower(connectionHeader) == "keep-alive" {
-			e.connMap[hostWithPort] = &conn
+			// Credentials stay with the origin they were given for.
+			if newURL.origin() == url.origin() {
+				if newURL.username == "" {
+					newURL.username, newURL.password = url.username, url.password
+				}
+			} else if _, ok := headers["Authorization"]; ok {
+				headers = maps.Clone(headers)
+				delete(headers, "Authorization")
+			}
+			e.emit(RedirectEvent{URL: url.String(), Location: newURL.String(), StatusCode: r.StatusCode})
+			return e.request(newURL, headers, sink)
 		}
-	} else {
-		conn.Close()
-		delete(e.connMap, hostWithPort)
 	}
 
-	if contentEncoding, ok := respHeaders["Content-Encoding"]; ok && strings.ToLower(contentEncoding) == "gzip" {
-		log.Println("Decompressing gzip body")
-		bodyData, err = decodeGzipBody(bodyData)
+	if contentEncoding, ok := r.Headers["Content-Encoding"]; ok && strings.ToLower(contentEncoding) == "gzip" && !r.streamed {
+		r.Body, err = decodeGzipBody(r.Body, &e.limits)
 		if err != nil {
-			return nil, err
+			return nil, requestError(url, ErrDecode, err)
 		}
-	}
-
-	if transferEncoding, ok := respHeaders["Transfer-Encoding"]; ok && strings.ToLower(transferEncoding) == "chunked" {
-		log.Println("Decoding chunked body")
-		bodyData, err = decodeChunkedBody(bodyData)
-		if err != nil {
-			return nil, err
+		if harEntry != nil {
+			e.har.setContent(harEntry, r.Headers, r.Body)
 		}
 	}
 
-	r := &Response{
-		URL:        url.String(),
-		StatusCode: statusCode,
-		Headers:    respHeaders,
-		Body:       bodyData,
-		ViewSource: url.ViewSource,
+	r.URL = url.String()
+	r.ViewSource = url.ViewSource
+
+	if r.streamed || r.StatusCode == 206 {
+		return r, nil
 	}
 
-	cacheControl, ok := respHeaders["Cache-Control"]
+	cacheControl, ok := r.Headers["Cache-Control"]
 	if ok && strings.Contains(cacheControl, "max-age") {
 		parts := strings.Split(cacheControl, "=")
 		if len(parts) != 2 {
@@ -285,55 +434,298 @@ func (e *Engine) Request(url *URL, headers map[string]string) (*Response, error)
 		if err != nil || maxAge <= 0 {
 			return r, nil
 		}
-		e.cache[url.String()] = NewCacheValue(r, int64(maxAge))
+		vary, ok := varyValues(r.Headers["Vary"], headers)
+		if !ok {
+			e.cacheError(url, e.cache.Evict(url.String(), headers))
+			return r, nil
+		}
+		// The caller gets r to do with as it likes, so the cache keeps a
+		// copy.
+		stored := *r
+		cacheValue := NewCacheValue(&stored, int64(maxAge))
+		cacheValue.Vary = vary
+		e.cacheError(url, e.cache.Store(url.String(), cacheValue))
 	} else {
-		delete(e.cache, url.String())
+		e.cacheError(url, e.cache.Evict(url.String(), headers))
 	}
 
 	return r, nil
 }
 
-func decodeChunkedBody(body []byte) ([]byte, error) {
-	decoded := bytes.Buffer{}
-	for i := 0; i < len(body); {
-		j := i
-		for j < len(body) && body[j] != '\r' {
-			j++
-		}
-		if j >= len(body)-1 || body[j] != '\r' || body[j+1] != '\n' {
-			return nil, fmt.Errorf("invalid chunked encoding")
-		}
-		sizeStr := string(body[i:j])
-		size, err := strconv.ParseInt(sizeStr, 16, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid chunk size: %v", err)
-		}
-		if size == 0 {
-			break
-		}
-		i = j + 2
-		if i+int(size) > len(body) {
-			return nil, fmt.Errorf("chunk size exceeds body length")
-		}
-		decoded.Write(body[i : i+int(size)])
-		i += int(size)
-		if i+1 >= len(body) || body[i] != '\r' || body[i+1] != '\n' {
-			return nil, fmt.Errorf("invalid chunked encoding after chunk data")
-		}
-		i += 2
+// cacheError reports a failure of the cache storage, which doesn't fail
+// the request it happened during.
+func (e *Engine) cacheError(url *URL, err error) {
+	if err != nil {
+		e.emit(CacheErrorEvent{URL: url.String(), Err: err})
+	}
+}
+
+// cacheKind names where cached responses come from, for HAR entries.
+func (e *Engine) cacheKind() string {
+	if _, ok := e.cache.(*DiskCache); ok {
+		return "disk"
+	}
+	return "memory"
+}
+
+// roundTrip sends one request for url over the network and reads the
+// response, without following redirects or decoding the body. It also
+// reports whether the request went out on a pooled connection.
+func (e *Engine) roundTrip(url *URL, proxyURL *neturl.URL, headers map[string]string, sink BodySink) (*Response, bool, error) {
+	timings := &Timings{Start: time.Now()}
+	trace := &requestTrace{url: url.String(), timings: timings, emit: e.emit, sink: sink, limits: &e.limits}
+	conn, h2, reused, err := e.connect(url, proxyURL, trace)
+	if err != nil {
+		return nil, false, requestError(url, ErrConnect, err)
 	}
-	return decoded.Bytes(), nil
+
+	var r *Response
+	if h2 != nil {
+		r, err = h2.roundTrip(url, headers, trace)
+	} else {
+		r, err = e.http1RoundTrip(conn, url, proxyURL, headers, trace)
+	}
+	if err != nil {
+		return nil, reused, requestError(url, ErrProtocol, err)
+	}
+	r.TLS = tlsInfo(conn, e.tlsConfig)
+	r.Connection = connectionInfo(conn, proxyURL, reused)
+	r.Timings = timings
+	return r, reused, nil
 }
 
-func decodeGzipBody(body []byte) ([]byte, error) {
+// decodeGzipBody decompresses body, giving up with a LimitError as soon as
+// the result grows past what limits allow.
+func decodeGzipBody(body []byte, limits *Limits) ([]byte, error) {
 	reader, err := gzip.NewReader(bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 	defer reader.Close()
-	decompressed, err := io.ReadAll(reader)
+
+	var exceeded *LimitError
+	maxBytes := limits.MaxDecodedBytes
+	if maxBytes > 0 {
+		exceeded = &LimitError{Limit: "MaxDecodedBytes", Max: maxBytes}
+	}
+	if ratio := int64(limits.MaxDecompressionRatio); ratio > 0 {
+		byRatio := max(int64(len(body))*ratio, MIN_RATIO_CHECK_BYTES)
+		if maxBytes <= 0 || byRatio < maxBytes {
+			maxBytes = byRatio
+			exceeded = &LimitError{Limit: "MaxDecompressionRatio", Max: ratio}
+		}
+	}
+	if maxBytes <= 0 {
+		return io.ReadAll(reader)
+	}
+	decompressed, err := io.ReadAll(io.LimitReader(reader, maxBytes+1))
 	if err != nil {
 		return nil, err
 	}
+	if int64(len(decompressed)) > maxBytes {
+		return nil, exceeded
+	}
 	return decompressed, nil
 }
+
+// gunzipSink passes gzip-encoded bodies its sink streams through a
+// gunzipWriter, so that they are decoded like bodies read into memory.
+type gunzipSink struct {
+	sink   BodySink
+	limits *Limits
+	w      *gunzipWriter
+}
+
+// bodySink returns the BodySink to make the request with.
+func (s *gunzipSink) bodySink() BodySink {
+	if s.sink == nil {
+		return nil
+	}
+	return s.take
+}
+
+func (s *gunzipSink) take(r *Response) (io.Writer, error) {
+	w, err := s.sink(r)
+	if w == nil || err != nil {
+		return w, err
+	}
+	if encoding, ok := getHeader(r.Headers, "Content-Encoding"); !ok || !strings.EqualFold(strings.TrimSpace(encoding), "gzip") {
+		return w, nil
+	}
+	// An earlier response to the same request may have been cut off.
+	s.finish(errors.New("response abandoned"))
+	s.w = newGunzipWriter(w, s.limits)
+	return s.w, nil
+}
+
+// finish waits for the body being decoded, if any, to be written out. The
+// request ended with err, which cuts the decoding short; finish returns an
+// error only when the body itself failed to decode.
+func (s *gunzipSink) finish(err error) error {
+	if s.w == nil {
+		return nil
+	}
+	w := s.w
+	s.w = nil
+	return w.close(err)
+}
+
+// gunzipWriter decompresses a gzip stream written to it into w as it
+// arrives, giving up with a LimitError once it grows by more than the
+// limits' MaxDecompressionRatio. Bodies streamed to a sink aren't held in
+// memory, so MaxDecodedBytes doesn't apply.
+type gunzipWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newGunzipWriter(w io.Writer, limits *Limits) *gunzipWriter {
+	pr, pw := io.Pipe()
+	g := &gunzipWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := decodeGzipStream(w, pr, limits)
+		// Writes past the end of the stream, or after a failure, fail.
+		pr.CloseWithError(err)
+		g.done <- err
+	}()
+	return g
+}
+
+func (g *gunzipWriter) Write(p []byte) (int, error) {
+	return g.pw.Write(p)
+}
+
+// close ends the stream, or cuts it short with cause, and returns the
+// decoding error unless it was cause.
+func (g *gunzipWriter) close(cause error) error {
+	if cause != nil {
+		g.pw.CloseWithError(cause)
+	} else {
+		g.pw.Close()
+	}
+	err := <-g.done
+	if cause != nil && errors.Is(err, cause) {
+		return nil
+	}
+	return err
+}
+
+// decodeGzipStream copies the gzip stream read from r into w decompressed.
+func decodeGzipStream(w io.Writer, r io.Reader, limits *Limits) error {
+	compressed := &countingReader{r: r}
+	reader, err := gzip.NewReader(compressed)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	buf := make([]byte, 32<<10)
+	var decoded int64
+	ratio := int64(limits.MaxDecompressionRatio)
+	for {
+		n, err := reader.Read(buf)
+		decoded += int64(n)
+		if ratio > 0 && decoded > max(compressed.n*ratio, MIN_RATIO_CHECK_BYTES) {
+			return &LimitError{Limit: "MaxDecompressionRatio", Max: ratio}
+		}
+		if _, werr := w.Write(buf[:n]); werr != nil {
+			return werr
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// getHeader looks up a header by name, falling back to a case-insensitive
+// match since servers don't always use canonical header names.
+func getHeader(headers map[string]string, name string) (string, bool) {
+	if value, ok := headers[name]; ok {
+		return value, true
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// BodySink chooses where the body of a response is written once its
+// headers have arrived. A nil writer keeps the body in Response.Body.
+// Downloads use it to save bodies to disk as they arrive.
+type BodySink func(r *Response) (io.Writer, error)
+
+// bodyWriter receives a response body as it is read, either into memory,
+// up to max bytes unless max is 0, or into the sink the request was made
+// with.
+type bodyWriter struct {
+	sink io.Writer
+	buf  bytes.Buffer
+	n    int
+	max  int64
+}
+
+func (w *bodyWriter) Write(p []byte) (int, error) {
+	if w.sink == nil {
+		if err := w.expect(int64(w.n + len(p))); err != nil {
+			return 0, err
+		}
+		w.buf.Write(p)
+		w.n += len(p)
+		return len(p), nil
+	}
+	n, err := w.sink.Write(p)
+	w.n += n
+	return n, err
+}
+
+// expect fails early for a body of size bytes that won't fit in memory.
+func (w *bodyWriter) expect(size int64) error {
+	if w.sink == nil && w.max > 0 && size > w.max {
+		return &LimitError{Limit: "MaxBodyBytes", Max: w.max}
+	}
+	return nil
+}
+
+// finish hands a body read into memory to r.
+func (w *bodyWriter) finish(r *Response) {
+	if w.sink == nil {
+		r.Body = w.buf.Bytes()
+	} else {
+		r.streamed = true
+	}
+}
+
+// streamBody passes the body of r, which is already in memory, to sink.
+func streamBody(url *URL, r *Response, sink BodySink) error {
+	if sink == nil {
+		return nil
+	}
+	w, err := (&requestTrace{url: url.String(), sink: sink}).newBodyWriter(r)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(r.Body); err != nil {
+		return err
+	}
+	if w.sink != nil {
+		r.Body = nil
+	}
+	w.finish(r)
+	return nil
+}
@@ -59,18 +59,17 @@ func TestRequest(t *testing.T) {
 		bigStr += "a"
 	}
 
-	go func() {
-		http.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
-			fmt.Fprintln(w, "Hello, World!")
-		})
-		http.HandleFunc("/index", func(w http.ResponseWriter, r *http.Request) {
-			fmt.Fprintln(w, "<html><body>Index Page</body></html>")
-		})
-		http.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
-			fmt.Fprintln(w, bigStr)
-		})
-		log.Fatal(http.ListenAndServe(":8080", nil))
-	}()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello, World!")
+	})
+	mux.HandleFunc("/index", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "<html><body>Index Page</body></html>")
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, bigStr)
+	})
+	transport := newPipeTransport(t, mux)
 
 	testCases := []struct {
 		url      string
@@ -90,7 +89,7 @@ func TestRequest(t *testing.T) {
 	<-done            // wait for file test case to be added
 	defer close(done) // close the channel to signal completion
 
-	e := NewEngine()
+	e := NewEngine(WithTransport(transport))
 
 	for _, tc := range testCases {
 		url, err := Parse(tc.url)
@@ -139,14 +138,13 @@ func addFileTestCases(testCases *[]struct {
 }
 
 func TestCustomHeaders(t *testing.T) {
-	go func() {
-		http.HandleFunc("/headers", func(w http.ResponseWriter, r *http.Request) {
-			customHeader := r.Header.Get("X-Custom-Header")
-			userAgent := r.Header.Get("User-Agent")
-			fmt.Fprintf(w, "%s\n%s", customHeader, userAgent)
-		})
-		log.Fatal(http.ListenAndServe(":8081", nil))
-	}()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/headers", func(w http.ResponseWriter, r *http.Request) {
+		customHeader := r.Header.Get("X-Custom-Header")
+		userAgent := r.Header.Get("User-Agent")
+		fmt.Fprintf(w, "%s\n%s", customHeader, userAgent)
+	})
+	transport := newPipeTransport(t, mux)
 
 	url, err := Parse("http://localhost:8081/headers")
 	if err != nil {
@@ -158,7 +156,7 @@ func TestCustomHeaders(t *testing.T) {
 		"User-Agent":      "GoTestClient/1.0",
 	}
 
-	e := NewEngine()
+	e := NewEngine(WithTransport(transport))
 
 	response, err := e.Request(url, headers)
 	if err != nil {
@@ -173,20 +171,19 @@ func TestCustomHeaders(t *testing.T) {
 
 func TestConnectionKeepAlive(t *testing.T) {
 	var connectionCount int
-	go func() {
-		http.HandleFunc("/keepalive", func(w http.ResponseWriter, r *http.Request) {
-			connectionCount++
-			fmt.Fprintf(w, "Connection count: %d", connectionCount)
-		})
-		log.Fatal(http.ListenAndServe(":8082", nil))
-	}()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keepalive", func(w http.ResponseWriter, r *http.Request) {
+		connectionCount++
+		fmt.Fprintf(w, "Connection count: %d", connectionCount)
+	})
+	transport := newPipeTransport(t, mux)
 
 	url, err := Parse("http://localhost:8082/keepalive")
 	if err != nil {
 		t.Fatalf("failed to parse URL: %v", err)
 	}
 
-	e := NewEngine()
+	e := NewEngine(WithTransport(transport))
 
 	for i := 1; i <= 3; i++ {
 		response, err := e.Request(url, nil)
@@ -202,15 +199,28 @@ func TestConnectionKeepAlive(t *testing.T) {
 }
 
 func TestRedirectHandling(t *testing.T) {
-	go func() {
-		http.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
-			http.Redirect(w, r, "/final", http.StatusFound)
-		})
-		http.HandleFunc("/final", func(w http.ResponseWriter, r *http.Request) {
-			fmt.Fprintln(w, "Final Destination")
-		})
-		log.Fatal(http.ListenAndServe(":8083", nil))
-	}()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/final", http.StatusFound)
+	})
+	mux.HandleFunc("/final", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Final Destination")
+	})
+	// A chain like the one browser.engineering serves, where each
+	// redirectN takes N hops to reach the page.
+	mux.HandleFunc("browser.engineering/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://browser.engineering/http.html", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("browser.engineering/redirect2", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/redirect", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("browser.engineering/redirect3", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/redirect2", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("browser.engineering/http.html", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "<html><body>HTTP</body></html>")
+	})
+	transport := newPipeTransport(t, mux)
 
 	testCases := []struct {
 		url   string
@@ -222,7 +232,7 @@ func TestRedirectHandling(t *testing.T) {
 		{"http://browser.engineering/redirect3", "http://browser.engineering/http.html"},
 	}
 
-	e := NewEngine()
+	e := NewEngine(WithTransport(transport))
 
 	for _, tc := range testCases {
 		url, err := Parse(tc.url)
@@ -241,21 +251,20 @@ func TestRedirectHandling(t *testing.T) {
 
 func TestCacheBehavior(t *testing.T) {
 	var requestCount int
-	go func() {
-		http.HandleFunc("/cache", func(w http.ResponseWriter, r *http.Request) {
-			requestCount++
-			w.Header().Set("Cache-Control", "max-age=2")
-			fmt.Fprintln(w, "Cached Content")
-		})
-		log.Fatal(http.ListenAndServe(":8084", nil))
-	}()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cache", func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		w.Header().Set("Cache-Control", "max-age=2")
+		fmt.Fprintln(w, "Cached Content")
+	})
+	transport := newPipeTransport(t, mux)
 
 	url, err := Parse("http://localhost:8084/cache")
 	if err != nil {
 		t.Fatalf("failed to parse URL: %v", err)
 	}
 
-	e := NewEngine()
+	e := NewEngine(WithTransport(transport))
 
 	// First request should hit the server
 	response, err := e.Request(url, nil)
@@ -298,19 +307,18 @@ func TestCacheBehavior(t *testing.T) {
 }
 
 func TestChunkedResponseHandling(t *testing.T) {
-	go func() {
-		http.HandleFunc("/chunked", func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Transfer-Encoding", "chunked")
-			w.Write([]byte("Hello, this is a chunked response."))
-		})
-		log.Fatal(http.ListenAndServe(":8085", nil))
-	}()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chunked", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Transfer-Encoding", "chunked")
+		w.Write([]byte("Hello, this is a chunked response."))
+	})
+	transport := newPipeTransport(t, mux)
 
 	url, err := Parse("http://localhost:8085/chunked")
 	if err != nil {
 		t.Fatalf("failed to parse URL: %v", err)
 	}
-	e := NewEngine()
+	e := NewEngine(WithTransport(transport))
 	response, err := e.Request(url, nil)
 	if err != nil {
 		t.Fatalf("request failed: %v", err)
@@ -324,22 +332,21 @@ func TestChunkedResponseHandling(t *testing.T) {
 }
 
 func TestGzipResponseHandling(t *testing.T) {
-	go func() {
-		http.HandleFunc("/gzip", func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Encoding", "gzip")
-			data := []byte("Hello, Gzip!")
-			gz := gzip.NewWriter(w)
-			gz.Write(data)
-			gz.Close()
-		})
-		log.Fatal(http.ListenAndServe(":8086", nil))
-	}()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		data := []byte("Hello, Gzip!")
+		gz := gzip.NewWriter(w)
+		gz.Write(data)
+		gz.Close()
+	})
+	transport := newPipeTransport(t, mux)
 
 	url, err := Parse("http://localhost:8086/gzip")
 	if err != nil {
 		t.Fatalf("failed to parse URL: %v", err)
 	}
-	e := NewEngine()
+	e := NewEngine(WithTransport(transport))
 	response, err := e.Request(url, nil)
 	if err != nil {
 		t.Fatalf("request failed: %v", err)
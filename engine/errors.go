@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// Sentinel errors classifying why a request failed. Errors returned by
+// Engine.Request match one of them with errors.Is, and still wrap the
+// underlying error for errors.As.
+var (
+	ErrDNS              = errors.New("DNS lookup failed")
+	ErrConnect          = errors.New("connection failed")
+	ErrTLS              = errors.New("TLS handshake failed")
+	ErrTimeout          = errors.New("timed out")
+	ErrProtocol         = errors.New("protocol error")
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrDecode           = errors.New("decoding failed")
+)
+
+// Error is a failed request for URL. Kind is one of the sentinel errors
+// above and Err the underlying cause, if there is one.
+type Error struct {
+	Kind error
+	URL  string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	if e.URL != "" {
+		b.WriteString(e.URL)
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Kind.Error())
+	if e.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+func newError(kind error, err error) *Error {
+	return &Error{Kind: kind, Err: err}
+}
+
+// requestError attaches url to err, classifying it as kind unless it
+// already is an *Error or is more specifically a DNS failure or timeout.
+func requestError(url *URL, kind error, err error) error {
+	var engineErr *Error
+	if errors.As(err, &engineErr) {
+		if engineErr.URL == "" {
+			engineErr.URL = url.String()
+		}
+		return engineErr
+	}
+	return &Error{Kind: classifyError(kind, err), URL: url.String(), Err: err}
+}
+
+func classifyError(kind error, err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTimeout {
+			return ErrTimeout
+		}
+		return ErrDNS
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+	// Resets and refusals surface as *net.OpError wherever they happen.
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return ErrConnect
+	}
+	return kind
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestErrorKinds(t *testing.T) {
+	refused, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	refusedAddr := refused.Addr().String()
+	refused.Close()
+
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+
+	loop := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/", http.StatusFound)
+	}))
+	defer loop.Close()
+
+	badGzip := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write([]byte("not gzip"))
+	}))
+	defer badGzip.Close()
+
+	garbage, _ := serveRawHTTP(t, func() (string, bool) {
+		return "SPDY/3 what\r\n\r\n", true
+	})
+
+	tests := []struct {
+		name string
+		url  string
+		kind error
+	}{
+		{"DNS", "http://nonexistent.invalid/", ErrDNS},
+		{"Connection refused", "http://" + refusedAddr + "/", ErrConnect},
+		{"Untrusted certificate", tlsServer.URL + "/", ErrTLS},
+		{"Redirect loop", loop.URL + "/", ErrTooManyRedirects},
+		{"Bad gzip body", badGzip.URL + "/", ErrDecode},
+		{"Invalid status line", "http://" + garbage + "/", ErrProtocol},
+		{"Invalid data URL", "data:text/plain;base64,!!!", ErrDecode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, err := Parse(tt.url)
+			if err != nil {
+				t.Fatalf("failed to parse URL: %v", err)
+			}
+			_, err = NewEngine().Request(url, nil)
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("expected %v, got %v", tt.kind, err)
+			}
+			var engineErr *Error
+			if !errors.As(err, &engineErr) {
+				t.Fatalf("expected *Error, got %T", err)
+			}
+			if engineErr.URL == "" {
+				t.Errorf("expected the failing URL on the error")
+			}
+		})
+	}
+}
+
+func TestErrorUnwrapsCause(t *testing.T) {
+	url, err := Parse("http://nonexistent.invalid/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	_, err = NewEngine().Request(url, nil)
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		t.Fatalf("expected a *net.DNSError in %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Event is something that happened while the engine handled a request.
+// It is one of the *Event types below.
+type Event interface {
+	event()
+}
+
+// RequestStartEvent starts every request, including each redirect hop.
+type RequestStartEvent struct {
+	URL string
+}
+
+// CacheLookupEvent reports whether a request was answered from the cache.
+type CacheLookupEvent struct {
+	URL string
+	Hit bool
+}
+
+// CacheErrorEvent reports that the cache storage failed to read or write
+// what a request looked up or stored. The request goes on regardless.
+type CacheErrorEvent struct {
+	URL string
+	Err error
+}
+
+// DNSEvent reports the addresses a host name resolved to, from the hosts
+// overrides or the engine's resolver. IP addresses aren't resolved.
+type DNSEvent struct {
+	URL      string
+	Host     string
+	Addrs    []string
+	Err      error
+	Duration time.Duration
+}
+
+// ConnectedEvent reports the connection a request will be sent on, which
+// is either new or reused from the keep-alive pool.
+type ConnectedEvent struct {
+	URL        string
+	RemoteAddr string
+	Proxy      string
+	Reused     bool
+	Duration   time.Duration
+}
+
+// TLSHandshakeEvent reports a completed TLS handshake.
+type TLSHandshakeEvent struct {
+	URL      string
+	TLS      *TLSInfo
+	Duration time.Duration
+}
+
+// HeadersReceivedEvent reports the status and headers of a response
+// before its body has been read.
+type HeadersReceivedEvent struct {
+	URL        string
+	StatusCode int
+	Proto      string
+	Headers    map[string]string
+}
+
+// BodyCompleteEvent reports that a response body has been read, with its
+// size as it came over the wire.
+type BodyCompleteEvent struct {
+	URL      string
+	Bytes    int
+	Duration time.Duration
+}
+
+// RedirectEvent reports a redirect the engine is about to follow.
+type RedirectEvent struct {
+	URL        string
+	Location   string
+	StatusCode int
+}
+
+// RetryEvent reports that a failed request is about to be retried after
+// Delay. Err is the error it failed with, or StatusCode the response that
+// asked for a retry.
+type RetryEvent struct {
+	URL        string
+	Attempt    int
+	Delay      time.Duration
+	Err        error
+	StatusCode int
+}
+
+// DownloadProgressEvent reports how much of a download has been saved to
+// Path. Total is -1 when the server didn't say how big it is. The last
+// event for a completed download has Done set.
+type DownloadProgressEvent struct {
+	URL      string
+	Path     string
+	Received int64
+	Total    int64
+	Done     bool
+}
+
+func (RequestStartEvent) event()     {}
+func (CacheLookupEvent) event()      {}
+func (CacheErrorEvent) event()       {}
+func (DNSEvent) event()              {}
+func (ConnectedEvent) event()        {}
+func (TLSHandshakeEvent) event()     {}
+func (HeadersReceivedEvent) event()  {}
+func (BodyCompleteEvent) event()     {}
+func (RedirectEvent) event()         {}
+func (RetryEvent) event()            {}
+func (DownloadProgressEvent) event() {}
+
+// Observer receives the events of every request an engine makes. Events
+// for concurrent requests, and HTTP/2 responses, can arrive from several
+// goroutines at once.
+type Observer interface {
+	Observe(event Event)
+}
+
+// ObserverFunc lets an ordinary function be used as an Observer.
+type ObserverFunc func(event Event)
+
+func (f ObserverFunc) Observe(event Event) {
+	f(event)
+}
+
+// WithObserver subscribes observer to the engine's events. Engines are
+// silent unless an observer is added.
+func WithObserver(observer Observer) Option {
+	return func(e *Engine) {
+		e.observers = append(e.observers, observer)
+	}
+}
+
+func (e *Engine) emit(event Event) {
+	for _, observer := range e.observers {
+		observer.Observe(event)
+	}
+}
+
+// SlogObserver logs events to logger at debug level, one record per event.
+func SlogObserver(logger *slog.Logger) Observer {
+	return ObserverFunc(func(event Event) {
+		msg, attrs := eventAttrs(event)
+		logger.LogAttrs(context.Background(), slog.LevelDebug, msg, attrs...)
+	})
+}
+
+func eventAttrs(event Event) (string, []slog.Attr) {
+	switch ev := event.(type) {
+	case RequestStartEvent:
+		return "request start", []slog.Attr{slog.String("url", ev.URL)}
+	case CacheLookupEvent:
+		msg := "cache miss"
+		if ev.Hit {
+			msg = "cache hit"
+		}
+		return msg, []slog.Attr{slog.String("url", ev.URL)}
+	case CacheErrorEvent:
+		return "cache error", []slog.Attr{slog.String("url", ev.URL), slog.Any("error", ev.Err)}
+	case DNSEvent:
+		attrs := []slog.Attr{
+			slog.String("url", ev.URL),
+			slog.String("host", ev.Host),
+			slog.Any("addrs", ev.Addrs),
+			slog.Duration("duration", ev.Duration),
+		}
+		if ev.Err != nil {
+			attrs = append(attrs, slog.Any("error", ev.Err))
+		}
+		return "dns resolved", attrs
+	case ConnectedEvent:
+		attrs := []slog.Attr{
+			slog.String("url", ev.URL),
+			slog.String("remote_addr", ev.RemoteAddr),
+			slog.Bool("reused", ev.Reused),
+			slog.Duration("duration", ev.Duration),
+		}
+		if ev.Proxy != "" {
+			attrs = append(attrs, slog.String("proxy", ev.Proxy))
+		}
+		return "connected", attrs
+	case TLSHandshakeEvent:
+		return "tls handshake", []slog.Attr{
+			slog.String("url", ev.URL),
+			slog.String("version", ev.TLS.VersionName()),
+			slog.String("cipher_suite", ev.TLS.CipherSuiteName()),
+			slog.String("alpn", ev.TLS.NegotiatedProtocol),
+			slog.Duration("duration", ev.Duration),
+		}
+	case HeadersReceivedEvent:
+		return "headers received", []slog.Attr{
+			slog.String("url", ev.URL),
+			slog.Int("status", ev.StatusCode),
+			slog.String("proto", ev.Proto),
+		}
+	case BodyCompleteEvent:
+		return "body complete", []slog.Attr{
+			slog.String("url", ev.URL),
+			slog.Int("bytes", ev.Bytes),
+			slog.Duration("duration", ev.Duration),
+		}
+	case RedirectEvent:
+		return "redirect", []slog.Attr{
+			slog.String("url", ev.URL),
+			slog.String("location", ev.Location),
+			slog.Int("status", ev.StatusCode),
+		}
+	case RetryEvent:
+		attrs := []slog.Attr{
+			slog.String("url", ev.URL),
+			slog.Int("attempt", ev.Attempt),
+			slog.Duration("delay", ev.Delay),
+		}
+		if ev.Err != nil {
+			attrs = append(attrs, slog.Any("error", ev.Err))
+		} else {
+			attrs = append(attrs, slog.Int("status", ev.StatusCode))
+		}
+		return "retry", attrs
+	case DownloadProgressEvent:
+		return "download progress", []slog.Attr{
+			slog.String("url", ev.URL),
+			slog.String("path", ev.Path),
+			slog.Int64("received", ev.Received),
+			slog.Int64("total", ev.Total),
+			slog.Bool("done", ev.Done),
+		}
+	}
+	return "event", nil
+}
+
+// requestTrace follows one network request through connecting and the
+// exchange, recording its timings and emitting its events.
+type requestTrace struct {
+	url     string
+	timings *Timings
+	emit    func(Event)
+	sink    BodySink
+	limits  *Limits
+}
+
+// newBodyWriter returns where the body of r goes: into the trace's sink if
+// it takes the response, or into memory.
+func (t *requestTrace) newBodyWriter(r *Response) (*bodyWriter, error) {
+	w := &bodyWriter{}
+	if t.limits != nil {
+		w.max = t.limits.MaxBodyBytes
+	}
+	if t.sink != nil {
+		r.URL = t.url
+		sink, err := t.sink(r)
+		if err != nil {
+			return nil, err
+		}
+		w.sink = sink
+	}
+	return w, nil
+}
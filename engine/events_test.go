@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) Observe(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+// kinds lists the types of the recorded events, e.g. "DNSEvent".
+func (r *eventRecorder) kinds() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kinds []string
+	for _, event := range r.events {
+		kinds = append(kinds, reflect.TypeOf(event).Name())
+	}
+	return kinds
+}
+
+func TestObserverEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "new page")
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	recorder := &eventRecorder{}
+	e := NewEngine(WithObserver(recorder))
+	for _, path := range []string{"/old", "/new"} {
+		url, err := Parse("http://localhost:" + port + path)
+		if err != nil {
+			t.Fatalf("failed to parse URL: %v", err)
+		}
+		if _, err := e.Request(url, map[string]string{"Connection": "keep-alive"}); err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+	}
+
+	expected := []string{
+		"RequestStartEvent", "CacheLookupEvent", "DNSEvent", "ConnectedEvent",
+		"HeadersReceivedEvent", "BodyCompleteEvent", "RedirectEvent",
+		"RequestStartEvent", "CacheLookupEvent", "ConnectedEvent",
+		"HeadersReceivedEvent", "BodyCompleteEvent",
+		"RequestStartEvent", "CacheLookupEvent",
+	}
+	if kinds := recorder.kinds(); !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("expected events %v, got %v", expected, kinds)
+	}
+
+	events := recorder.events
+	if dns := events[2].(DNSEvent); dns.Host != "localhost" || len(dns.Addrs) == 0 {
+		t.Errorf("expected localhost to resolve, got %+v", dns)
+	}
+	if connected := events[9].(ConnectedEvent); !connected.Reused {
+		t.Errorf("expected the redirect target to reuse the connection")
+	}
+	if redirect := events[6].(RedirectEvent); redirect.StatusCode != http.StatusMovedPermanently || !strings.HasSuffix(redirect.Location, "/new") {
+		t.Errorf("expected a 301 to /new, got %+v", redirect)
+	}
+	if hit := events[13].(CacheLookupEvent); !hit.Hit {
+		t.Errorf("expected the last request to hit the cache")
+	}
+}
+
+func TestObserverEventsHTTP2(t *testing.T) {
+	server, _ := newHTTP2Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer server.Close()
+
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tlsConfig.NextProtos = nil
+	recorder := &eventRecorder{}
+	e := NewEngine(WithTLSConfig(tlsConfig), WithObserver(recorder))
+
+	url, err := Parse(server.URL + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	if _, err := e.Request(url, nil); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	expected := []string{
+		"RequestStartEvent", "CacheLookupEvent", "ConnectedEvent", "TLSHandshakeEvent",
+		"HeadersReceivedEvent", "BodyCompleteEvent",
+	}
+	if kinds := recorder.kinds(); !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("expected events %v, got %v", expected, kinds)
+	}
+	if handshake := recorder.events[3].(TLSHandshakeEvent); handshake.TLS.NegotiatedProtocol != "h2" {
+		t.Errorf("expected h2 to be negotiated, got %q", handshake.TLS.NegotiatedProtocol)
+	}
+	if headers := recorder.events[4].(HeadersReceivedEvent); headers.Proto != "HTTP/2.0" || headers.StatusCode != http.StatusOK {
+		t.Errorf("expected HTTP/2.0 200, got %s %d", headers.Proto, headers.StatusCode)
+	}
+	if body := recorder.events[5].(BodyCompleteEvent); body.Bytes != 5 {
+		t.Errorf("expected 5 body bytes, got %d", body.Bytes)
+	}
+}
+
+func TestSlogObserver(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	transport := newPipeTransport(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	e := NewEngine(WithTransport(transport), WithObserver(SlogObserver(logger)))
+
+	url, err := Parse("http://example.com/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	if _, err := e.Request(url, nil); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	for _, want := range []string{`msg="request start" url=http://example.com/`, `msg="cache miss"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected log to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// DEFAULT_HAPPY_EYEBALLS_DELAY is the head start each connection attempt
+// gets before the next address is tried, as recommended by RFC 8305.
+const DEFAULT_HAPPY_EYEBALLS_DELAY = 250 * time.Millisecond
+
+// WithHappyEyeballsDelay sets how long a connection attempt may take
+// before the engine starts racing it against the next address. Zero
+// tries every address at once.
+func WithHappyEyeballsDelay(delay time.Duration) Option {
+	return func(e *Engine) {
+		e.happyEyeballsDelay = delay
+	}
+}
+
+// dialRace connects to one of addrs on port the way RFC 8305 describes:
+// attempts alternate between IPv6 and IPv4, each new attempt starts when
+// the previous one fails or has had its head start, and the first
+// connection to succeed wins.
+func (e *Engine) dialRace(ctx context.Context, addrs []netip.Addr, port string) (net.Conn, error) {
+	addrs = interleaveFamilies(addrs)
+	if len(addrs) == 1 {
+		return e.transport.DialContext(ctx, "tcp", net.JoinHostPort(addrs[0].String(), port))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	next, pending := 0, 0
+	attempt := func() {
+		address := net.JoinHostPort(addrs[next].String(), port)
+		next++
+		pending++
+		go func() {
+			conn, err := e.transport.DialContext(ctx, "tcp", address)
+			results <- result{conn, err}
+		}()
+	}
+
+	attempt()
+	timer := time.NewTimer(e.happyEyeballsDelay)
+	defer timer.Stop()
+	var firstErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// Attempts still running lose the race; close any that
+				// connect before noticing the cancellation.
+				go func(pending int) {
+					for range pending {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				attempt()
+				timer.Reset(e.happyEyeballsDelay)
+			}
+		case <-timer.C:
+			if next < len(addrs) {
+				attempt()
+				timer.Reset(e.happyEyeballsDelay)
+			}
+		}
+	}
+	return nil, firstErr
+}
+
+// interleaveFamilies orders addrs to alternate between IPv6 and IPv4,
+// starting with IPv6 and otherwise keeping the resolver's order.
+func interleaveFamilies(addrs []netip.Addr) []netip.Addr {
+	var v6, v4 []netip.Addr
+	for _, addr := range addrs {
+		if addr.Is6() {
+			v6 = append(v6, addr)
+		} else {
+			v4 = append(v4, addr)
+		}
+	}
+	ordered := make([]netip.Addr, 0, len(addrs))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			ordered = append(ordered, v6[i])
+		}
+		if i < len(v4) {
+			ordered = append(ordered, v4[i])
+		}
+	}
+	return ordered
+}
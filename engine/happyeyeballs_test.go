@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInterleaveFamilies(t *testing.T) {
+	var addrs []netip.Addr
+	for _, s := range []string{"192.0.2.1", "192.0.2.2", "2001:db8::1", "192.0.2.3", "2001:db8::2"} {
+		addrs = append(addrs, netip.MustParseAddr(s))
+	}
+	var got []string
+	for _, addr := range interleaveFamilies(addrs) {
+		got = append(got, addr.String())
+	}
+	expected := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "192.0.2.3"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+// racingTransport connects IPv4 addresses to an in-memory server and
+// leaves IPv6 attempts hanging, like a host with broken IPv6, unless
+// ipv6Works is set.
+type racingTransport struct {
+	*pipeTransport
+	ipv6Works bool
+
+	mu       sync.Mutex
+	attempts []string
+	canceled []string
+}
+
+func (r *racingTransport) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	r.mu.Lock()
+	r.attempts = append(r.attempts, address)
+	r.mu.Unlock()
+	if strings.HasPrefix(address, "[") && !r.ipv6Works {
+		<-ctx.Done()
+		r.mu.Lock()
+		r.canceled = append(r.canceled, address)
+		r.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	return r.pipeTransport.DialContext(ctx, network, address)
+}
+
+func TestHappyEyeballs(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("connected"))
+	})
+	resolver := &countingResolver{
+		addrs: []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")},
+		ttl:   time.Minute,
+	}
+
+	tests := []struct {
+		name      string
+		ipv6Works bool
+		attempts  []string
+	}{
+		{"Broken IPv6 falls back to IPv4", false, []string{"[2001:db8::1]:80", "192.0.2.1:80"}},
+		{"Working IPv6 wins", true, []string{"[2001:db8::1]:80"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &racingTransport{pipeTransport: newPipeTransport(t, handler), ipv6Works: tt.ipv6Works}
+			e := NewEngine(
+				WithTransport(transport),
+				WithResolver(resolver),
+				WithHappyEyeballsDelay(50*time.Millisecond),
+			)
+			url, err := Parse("http://dual.example/")
+			if err != nil {
+				t.Fatalf("failed to parse URL: %v", err)
+			}
+			start := time.Now()
+			response, err := e.Request(url, nil)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if string(response.Body) != "connected" {
+				t.Errorf("expected %q, got %q", "connected", response.Body)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected the race to finish quickly, took %v", elapsed)
+			}
+
+			transport.mu.Lock()
+			defer transport.mu.Unlock()
+			if !reflect.DeepEqual(transport.attempts, tt.attempts) {
+				t.Errorf("expected attempts %v, got %v", tt.attempts, transport.attempts)
+			}
+		})
+	}
+}
+
+func TestHappyEyeballsCancelsLosers(t *testing.T) {
+	transport := &racingTransport{pipeTransport: newPipeTransport(t, http.NotFoundHandler())}
+	e := NewEngine(WithTransport(transport), WithHappyEyeballsDelay(10*time.Millisecond))
+	addrs := []netip.Addr{netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("192.0.2.1")}
+
+	conn, err := e.dialRace(context.Background(), addrs, "80")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		transport.mu.Lock()
+		canceled := len(transport.canceled)
+		transport.mu.Unlock()
+		if canceled == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the IPv6 attempt to be canceled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,259 @@
+package engine
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	neturl "net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR collects the requests an engine makes in the HTTP Archive 1.2
+// format (http://www.softwareishard.com/blog/har-12-spec/) used by
+// browser developer tools. Attach one to an engine with WithHAR.
+type HAR struct {
+	mu  sync.Mutex
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string      `json:"version"`
+	Creator HARCreator  `json:"creator"`
+	Entries []*HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           HARCache    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	// FromCache is "memory" or "disk" when the response came from the
+	// engine's cache, as in the HAR files Chrome writes.
+	FromCache string `json:"_fromCache,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARContent struct {
+	Size        int    `json:"size"`
+	Compression int    `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+// HARCache is left empty; the engine's cache doesn't track the entry
+// details HAR asks for.
+type HARCache struct{}
+
+// HARTimings are in milliseconds, with -1 for phases that didn't happen.
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// NewHAR creates an empty archive.
+func NewHAR() *HAR {
+	return &HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "browser-engineering-go", Version: "1.0"},
+		Entries: []*HAREntry{},
+	}}
+}
+
+// WithHAR adds every request the engine handles to har, including each
+// redirect hop and responses served from the cache.
+func WithHAR(har *HAR) Option {
+	return func(e *Engine) {
+		e.har = har
+	}
+}
+
+// WriteTo writes the archive as JSON.
+func (h *HAR) WriteTo(w io.Writer) (int64, error) {
+	h.mu.Lock()
+	data, err := json.MarshalIndent(h, "", "  ")
+	h.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// Save writes the archive to path.
+func (h *HAR) Save(path string) error {
+	var b strings.Builder
+	if _, err := h.WriteTo(&b); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(b.String()))
+}
+
+// add records the request for url and the response it got. The body is
+// still encoded as it arrived; setContent fills in the decoded content.
+func (h *HAR) add(url *URL, headers map[string]string, r *Response, fromCache string) *HAREntry {
+	// Requests go out as HTTP/1.1 unless the connection speaks HTTP/2,
+	// whatever version the server answers with.
+	requestProto := "HTTP/1.1"
+	if r.Proto == "HTTP/2.0" {
+		requestProto = r.Proto
+	}
+	entry := &HAREntry{
+		StartedDateTime: time.Now(),
+		Request: HARRequest{
+			Method:      "GET",
+			URL:         url.String(),
+			HTTPVersion: requestProto,
+			Cookies:     []HARNameValue{},
+			Headers:     harHeaders(headers),
+			QueryString: harQueryString(url.path),
+			HeadersSize: -1,
+			BodySize:    0,
+		},
+		Response: HARResponse{
+			Status:      r.StatusCode,
+			StatusText:  r.Reason,
+			HTTPVersion: r.Proto,
+			Cookies:     []HARNameValue{},
+			Headers:     harHeaders(r.Headers),
+			Content:     harContent(r.Headers, r.Body),
+			HeadersSize: -1,
+			BodySize:    len(r.Body),
+		},
+		Timings:   HARTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1},
+		FromCache: fromCache,
+	}
+	if location, ok := r.Headers["Location"]; ok && r.StatusCode >= 300 && r.StatusCode < 400 {
+		entry.Response.RedirectURL = location
+	}
+	if fromCache != "" {
+		entry.Response.BodySize = 0
+	}
+	if t := r.Timings; t != nil {
+		entry.StartedDateTime = t.Start
+		entry.Time = harMillis(t.Total())
+		entry.Timings.Send = harMillis(t.Send)
+		entry.Timings.Wait = harMillis(t.Wait)
+		entry.Timings.Receive = harMillis(t.Receive)
+		if t.DNS > 0 {
+			entry.Timings.DNS = harMillis(t.DNS)
+		}
+		// HAR counts the TLS handshake as part of connecting.
+		if t.Connect > 0 || t.TLS > 0 {
+			entry.Timings.Connect = harMillis(t.Connect + t.TLS)
+		}
+		if t.TLS > 0 {
+			entry.Timings.SSL = harMillis(t.TLS)
+		}
+	}
+	if r.Connection != nil {
+		if host, _, err := net.SplitHostPort(r.Connection.RemoteAddr); err == nil {
+			entry.ServerIPAddress = host
+		}
+	}
+
+	h.mu.Lock()
+	h.Log.Entries = append(h.Log.Entries, entry)
+	h.mu.Unlock()
+	return entry
+}
+
+// setContent replaces the content of entry with the decoded body.
+func (h *HAR) setContent(entry *HAREntry, headers map[string]string, body []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry.Response.Content = harContent(headers, body)
+	entry.Response.Content.Compression = len(body) - entry.Response.BodySize
+}
+
+func harContent(headers map[string]string, body []byte) HARContent {
+	content := HARContent{Size: len(body), MimeType: headers["Content-Type"]}
+	if utf8.Valid(body) {
+		content.Text = string(body)
+	} else {
+		content.Text = base64.StdEncoding.EncodeToString(body)
+		content.Encoding = "base64"
+	}
+	return content
+}
+
+func harHeaders(headers map[string]string) []HARNameValue {
+	pairs := []HARNameValue{}
+	for name, value := range headers {
+		pairs = append(pairs, HARNameValue{name, value})
+	}
+	slices.SortFunc(pairs, func(a, b HARNameValue) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return pairs
+}
+
+func harQueryString(path string) []HARNameValue {
+	pairs := []HARNameValue{}
+	_, query, ok := strings.Cut(path, "?")
+	if !ok {
+		return pairs
+	}
+	values, err := neturl.ParseQuery(query)
+	if err != nil {
+		return pairs
+	}
+	for name, list := range values {
+		for _, value := range list {
+			pairs = append(pairs, HARNameValue{name, value})
+		}
+	}
+	slices.SortFunc(pairs, func(a, b HARNameValue) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return pairs
+}
+
+func harMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHAR(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/start":
+			http.Redirect(w, r, "/page?lang=en", http.StatusFound)
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Cache-Control", "max-age=60")
+			gz := gzip.NewWriter(w)
+			fmt.Fprint(gz, strings.Repeat("<p>hello</p>", 100))
+			gz.Close()
+		}
+	}))
+	defer server.Close()
+
+	// Going through localhost makes the engine resolve a name.
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	base := "http://localhost:" + port
+
+	har := NewHAR()
+	e := NewEngine(WithHAR(har))
+	for _, rawURL := range []string{base + "/start", base + "/page?lang=en"} {
+		url, err := Parse(rawURL)
+		if err != nil {
+			t.Fatalf("failed to parse URL: %v", err)
+		}
+		if _, err := e.Request(url, nil); err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+	}
+
+	entries := har.Log.Entries
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+
+	redirect := entries[0]
+	if redirect.Response.Status != http.StatusFound || redirect.Response.RedirectURL != "/page?lang=en" {
+		t.Errorf("expected a redirect to /page?lang=en, got %d %q", redirect.Response.Status, redirect.Response.RedirectURL)
+	}
+	if redirect.Timings.DNS < 0 || redirect.Timings.Connect < 0 {
+		t.Errorf("expected DNS and connect timings for a new connection, got %+v", redirect.Timings)
+	}
+	if redirect.Timings.SSL != -1 {
+		t.Errorf("expected no TLS timing for http, got %v", redirect.Timings.SSL)
+	}
+	if redirect.ServerIPAddress == "" {
+		t.Errorf("expected the server address to be recorded")
+	}
+
+	page := entries[1]
+	if len(page.Request.QueryString) != 1 || page.Request.QueryString[0] != (HARNameValue{"lang", "en"}) {
+		t.Errorf("expected query string lang=en, got %v", page.Request.QueryString)
+	}
+	if page.Response.Content.Size != 1200 || page.Response.Content.MimeType != "text/html" {
+		t.Errorf("expected 1200 bytes of text/html, got %d of %q", page.Response.Content.Size, page.Response.Content.MimeType)
+	}
+	if page.Response.BodySize >= page.Response.Content.Size || page.Response.Content.Compression != page.Response.Content.Size-page.Response.BodySize {
+		t.Errorf("expected a compressed body, got %d bytes for %d", page.Response.BodySize, page.Response.Content.Size)
+	}
+	if page.FromCache != "" {
+		t.Errorf("expected the first load to miss the cache")
+	}
+
+	cached := entries[2]
+	if cached.FromCache != "memory" {
+		t.Errorf("expected the second load to come from the memory cache, got %q", cached.FromCache)
+	}
+
+	var out strings.Builder
+	if _, err := har.WriteTo(&out); err != nil {
+		t.Fatalf("failed to write HAR: %v", err)
+	}
+	var decoded struct {
+		Log struct {
+			Version string            `json:"version"`
+			Entries []json.RawMessage `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &decoded); err != nil {
+		t.Fatalf("invalid HAR JSON: %v", err)
+	}
+	if decoded.Log.Version != "1.2" || len(decoded.Log.Entries) != 3 {
+		t.Errorf("expected a HAR 1.2 log with 3 entries, got version %q with %d", decoded.Log.Version, len(decoded.Log.Entries))
+	}
+}
@@ -0,0 +1,295 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// HPACK (RFC 7541) header compression for HTTP/2.
+
+const hpackDefaultTableSize = 4096
+
+var errHPACK = errors.New("hpack: invalid header block")
+
+type hpackField struct {
+	name  string
+	value string
+}
+
+// size is the space a field takes up in the dynamic table.
+func (f hpackField) size() int {
+	return len(f.name) + len(f.value) + 32
+}
+
+// hpackDecoder decodes the header blocks of one connection. Header blocks
+// must be decoded in the order they arrive since they share the dynamic
+// table.
+type hpackDecoder struct {
+	dynamic    []hpackField // newest first
+	size       int
+	maxSize    int
+	maxAllowed int
+}
+
+func newHPACKDecoder() *hpackDecoder {
+	return &hpackDecoder{
+		maxSize:    hpackDefaultTableSize,
+		maxAllowed: hpackDefaultTableSize,
+	}
+}
+
+func (d *hpackDecoder) decode(block []byte) ([]hpackField, error) {
+	var fields []hpackField
+	for len(block) > 0 {
+		b := block[0]
+		switch {
+		case b&0x80 != 0: // indexed header field
+			index, rest, err := hpackReadInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			field, err := d.field(index)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, field)
+			block = rest
+
+		case b&0xc0 == 0x40: // literal with incremental indexing
+			field, rest, err := d.literal(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.add(field)
+			fields = append(fields, field)
+			block = rest
+
+		case b&0xe0 == 0x20: // dynamic table size update
+			size, rest, err := hpackReadInt(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > uint64(d.maxAllowed) {
+				return nil, fmt.Errorf("%w: table size %d above limit", errHPACK, size)
+			}
+			d.maxSize = int(size)
+			d.evict()
+			block = rest
+
+		default: // literal without indexing or never indexed
+			field, rest, err := d.literal(block, 4)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, field)
+			block = rest
+		}
+	}
+	return fields, nil
+}
+
+// literal reads a literal field whose name index has the given prefix.
+func (d *hpackDecoder) literal(block []byte, prefix uint8) (hpackField, []byte, error) {
+	index, rest, err := hpackReadInt(block, prefix)
+	if err != nil {
+		return hpackField{}, nil, err
+	}
+	var field hpackField
+	if index == 0 {
+		if field.name, rest, err = hpackReadString(rest); err != nil {
+			return hpackField{}, nil, err
+		}
+	} else {
+		named, err := d.field(index)
+		if err != nil {
+			return hpackField{}, nil, err
+		}
+		field.name = named.name
+	}
+	if field.value, rest, err = hpackReadString(rest); err != nil {
+		return hpackField{}, nil, err
+	}
+	return field, rest, nil
+}
+
+func (d *hpackDecoder) field(index uint64) (hpackField, error) {
+	if index == 0 {
+		return hpackField{}, fmt.Errorf("%w: index 0", errHPACK)
+	}
+	if index <= uint64(len(hpackStaticTable)) {
+		return hpackStaticTable[index-1], nil
+	}
+	index -= uint64(len(hpackStaticTable)) + 1
+	if index >= uint64(len(d.dynamic)) {
+		return hpackField{}, fmt.Errorf("%w: index out of range", errHPACK)
+	}
+	return d.dynamic[index], nil
+}
+
+func (d *hpackDecoder) add(field hpackField) {
+	d.dynamic = append([]hpackField{field}, d.dynamic...)
+	d.size += field.size()
+	d.evict()
+}
+
+func (d *hpackDecoder) evict() {
+	for d.size > d.maxSize && len(d.dynamic) > 0 {
+		last := d.dynamic[len(d.dynamic)-1]
+		d.dynamic = d.dynamic[:len(d.dynamic)-1]
+		d.size -= last.size()
+	}
+}
+
+// hpackEncode encodes fields without touching the peer's dynamic table,
+// using the static table to shorten well-known names and values.
+func hpackEncode(fields []hpackField) []byte {
+	var block []byte
+	for _, field := range fields {
+		nameIndex := 0
+		exact := 0
+		for i, static := range hpackStaticTable {
+			if static.name != field.name {
+				continue
+			}
+			if nameIndex == 0 {
+				nameIndex = i + 1
+			}
+			if static.value == field.value {
+				exact = i + 1
+				break
+			}
+		}
+		if exact != 0 {
+			block = hpackAppendInt(block, 0x80, 7, uint64(exact))
+			continue
+		}
+		block = hpackAppendInt(block, 0x00, 4, uint64(nameIndex))
+		if nameIndex == 0 {
+			block = hpackAppendString(block, field.name)
+		}
+		block = hpackAppendString(block, field.value)
+	}
+	return block
+}
+
+func hpackAppendInt(dst []byte, flags byte, prefix uint8, value uint64) []byte {
+	max := uint64(1)<<prefix - 1
+	if value < max {
+		return append(dst, flags|byte(value))
+	}
+	dst = append(dst, flags|byte(max))
+	value -= max
+	for value >= 0x80 {
+		dst = append(dst, byte(value&0x7f)|0x80)
+		value >>= 7
+	}
+	return append(dst, byte(value))
+}
+
+func hpackAppendString(dst []byte, s string) []byte {
+	dst = hpackAppendInt(dst, 0x00, 7, uint64(len(s)))
+	return append(dst, s...)
+}
+
+func hpackReadInt(block []byte, prefix uint8) (uint64, []byte, error) {
+	if len(block) == 0 {
+		return 0, nil, fmt.Errorf("%w: truncated integer", errHPACK)
+	}
+	max := uint64(1)<<prefix - 1
+	value := uint64(block[0]) & max
+	block = block[1:]
+	if value < max {
+		return value, block, nil
+	}
+	for shift := uint(0); ; shift += 7 {
+		if len(block) == 0 {
+			return 0, nil, fmt.Errorf("%w: truncated integer", errHPACK)
+		}
+		if shift > 56 {
+			return 0, nil, fmt.Errorf("%w: integer overflow", errHPACK)
+		}
+		b := block[0]
+		block = block[1:]
+		value += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return value, block, nil
+		}
+	}
+}
+
+func hpackReadString(block []byte) (string, []byte, error) {
+	if len(block) == 0 {
+		return "", nil, fmt.Errorf("%w: truncated string", errHPACK)
+	}
+	huffman := block[0]&0x80 != 0
+	length, rest, err := hpackReadInt(block, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(rest)) < length {
+		return "", nil, fmt.Errorf("%w: truncated string", errHPACK)
+	}
+	data := rest[:length]
+	rest = rest[length:]
+	if !huffman {
+		return string(data), rest, nil
+	}
+	decoded, err := huffmanDecode(data)
+	if err != nil {
+		return "", nil, err
+	}
+	return decoded, rest, nil
+}
+
+type huffmanNode struct {
+	children [2]*huffmanNode
+	sym      int
+}
+
+var huffmanRoot = buildHuffmanTree()
+
+func buildHuffmanTree() *huffmanNode {
+	root := &huffmanNode{sym: -1}
+	for sym, code := range huffmanCodes {
+		node := root
+		for i := int(huffmanCodeLens[sym]) - 1; i >= 0; i-- {
+			bit := (code >> uint(i)) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &huffmanNode{sym: -1}
+			}
+			node = node.children[bit]
+		}
+		node.sym = sym
+	}
+	return root
+}
+
+// huffmanDecode decodes a Huffman coded string. The final byte may be
+// padded with at most 7 one bits, the prefix of the EOS code.
+func huffmanDecode(data []byte) (string, error) {
+	var out []byte
+	node := huffmanRoot
+	pending := 0
+	allOnes := true
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> uint(i)) & 1
+			node = node.children[bit]
+			if node == nil {
+				return "", fmt.Errorf("%w: bad huffman code", errHPACK)
+			}
+			pending++
+			allOnes = allOnes && bit == 1
+			if node.sym >= 0 {
+				out = append(out, byte(node.sym))
+				node = huffmanRoot
+				pending = 0
+				allOnes = true
+			}
+		}
+	}
+	if pending > 7 || !allOnes {
+		return "", fmt.Errorf("%w: bad huffman padding", errHPACK)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type hstsEntry struct {
+	Expires           time.Time `json:"expires"`
+	IncludeSubDomains bool      `json:"include_subdomains"`
+}
+
+// HSTSStore remembers which hosts asked, through Strict-Transport-Security,
+// to only be reached over https. Plain http URLs for those hosts are
+// upgraded before the engine connects.
+type HSTSStore struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]hstsEntry
+	preload map[string]bool
+}
+
+// NewHSTSStore creates a store that lives only in memory.
+func NewHSTSStore() *HSTSStore {
+	return &HSTSStore{
+		entries: make(map[string]hstsEntry),
+		preload: make(map[string]bool),
+	}
+}
+
+// OpenHSTSStore loads the store saved at path and keeps it up to date as
+// new policies are recorded. A missing or unreadable file starts empty.
+func OpenHSTSStore(path string) (*HSTSStore, error) {
+	s := NewHSTSStore()
+	s.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.entries); err != nil {
+		s.entries = make(map[string]hstsEntry)
+	}
+	return s, nil
+}
+
+// WithHSTSStore makes the engine use store for Strict-Transport-Security
+// policies.
+func WithHSTSStore(store *HSTSStore) Option {
+	return func(e *Engine) {
+		e.hsts = store
+	}
+}
+
+// Preload marks host as https-only the way a browser's built-in preload
+// list does. Preloaded hosts never expire.
+func (s *HSTSStore) Preload(host string, includeSubDomains bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.preload[strings.ToLower(host)] = includeSubDomains
+}
+
+// Record applies a Strict-Transport-Security header received from host
+// over https. A max-age of zero removes the host's policy.
+func (s *HSTSStore) Record(host, header string) {
+	host = strings.ToLower(host)
+	if net.ParseIP(host) != nil {
+		return
+	}
+
+	maxAge := -1
+	includeSubDomains := false
+	for _, directive := range strings.Split(header, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "max-age":
+			age, err := strconv.Atoi(strings.Trim(strings.TrimSpace(value), `"`))
+			if err != nil || age < 0 {
+				return
+			}
+			maxAge = age
+		case "includesubdomains":
+			includeSubDomains = true
+		}
+	}
+	if maxAge < 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if maxAge == 0 {
+		delete(s.entries, host)
+	} else {
+		s.entries[host] = hstsEntry{
+			Expires:           time.Now().Add(time.Duration(maxAge) * time.Second),
+			IncludeSubDomains: includeSubDomains,
+		}
+	}
+	s.save()
+}
+
+// ShouldUpgrade reports whether http requests to host must use https
+// instead, either because of its own policy or a parent domain's policy
+// that includes subdomains.
+func (s *HSTSStore) ShouldUpgrade(host string) bool {
+	host = strings.ToLower(host)
+	if net.ParseIP(host) != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for domain, first := host, true; domain != ""; first = false {
+		if includeSubDomains, ok := s.preload[domain]; ok && (first || includeSubDomains) {
+			return true
+		}
+		if entry, ok := s.entries[domain]; ok {
+			if time.Now().After(entry.Expires) {
+				delete(s.entries, domain)
+			} else if first || entry.IncludeSubDomains {
+				return true
+			}
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		domain = parent
+	}
+	return false
+}
+
+func (s *HSTSStore) save() {
+	if s.path == "" {
+		return
+	}
+	data, err := json.Marshal(s.entries)
+	if err != nil {
+		return
+	}
+	writeFileAtomic(s.path, data)
+}
+
+// upgradeToHTTPS returns the https equivalent of an http URL, keeping an
+// explicit port other than 80.
+func upgradeToHTTPS(url *URL) *URL {
+	upgraded := *url
+	upgraded.scheme = "https"
+	upgraded.port = "443"
+	upgraded.host = strings.TrimSuffix(url.host, ":80")
+	return &upgraded
+}
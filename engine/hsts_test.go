@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestHSTSStore(t *testing.T) {
+	s := NewHSTSStore()
+	s.Record("example.com", "max-age=3600; includeSubDomains")
+	s.Record("other.com", "max-age=3600")
+	s.Record("127.0.0.1", "max-age=3600")
+	s.Preload("preloaded.test", false)
+
+	tests := []struct {
+		host     string
+		expected bool
+	}{
+		{"example.com", true},
+		{"EXAMPLE.com", true},
+		{"www.example.com", true},
+		{"other.com", true},
+		{"www.other.com", false},
+		{"notexample.com", false},
+		{"127.0.0.1", false},
+		{"preloaded.test", true},
+		{"sub.preloaded.test", false},
+	}
+	for _, test := range tests {
+		if result := s.ShouldUpgrade(test.host); result != test.expected {
+			t.Errorf("ShouldUpgrade(%q) = %v, expected %v", test.host, result, test.expected)
+		}
+	}
+
+	s.Record("example.com", "max-age=0")
+	if s.ShouldUpgrade("example.com") {
+		t.Errorf("expected max-age=0 to remove the policy")
+	}
+}
+
+func TestHSTSUpgrade(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", "max-age=3600")
+		fmt.Fprint(w, "secure")
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	// The test certificate is issued for example.com, so verify against
+	// that name while connecting to localhost.
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tlsConfig.ServerName = "example.com"
+
+	path := filepath.Join(t.TempDir(), "hsts.json")
+	store, err := OpenHSTSStore(path)
+	if err != nil {
+		t.Fatalf("failed to open HSTS store: %v", err)
+	}
+	e := NewEngine(WithTLSConfig(tlsConfig), WithHSTSStore(store))
+
+	secureURL, err := Parse("https://localhost:" + port + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	if _, err := e.Request(secureURL, nil); err != nil {
+		t.Fatalf("https request failed: %v", err)
+	}
+
+	reopened, err := OpenHSTSStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen HSTS store: %v", err)
+	}
+	if !reopened.ShouldUpgrade("localhost") {
+		t.Fatalf("expected the recorded policy to be persisted")
+	}
+
+	// The server only speaks TLS, so the plain http request only succeeds
+	// when it gets upgraded.
+	e = NewEngine(WithTLSConfig(tlsConfig), WithHSTSStore(reopened))
+	insecureURL, err := Parse("http://localhost:" + port + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	response, err := e.Request(insecureURL, nil)
+	if err != nil {
+		t.Fatalf("upgraded request failed: %v", err)
+	}
+	if response.URL != "https://localhost:"+port+"/" || string(response.Body) != "secure" {
+		t.Errorf("expected upgraded https response, got %q from %s", string(response.Body), response.URL)
+	}
+}
@@ -0,0 +1,310 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestTarget returns what the request line names for url. Plain http
+// requests through a proxy name the full URL so the proxy knows where to
+// forward them.
+func requestTarget(url *URL, proxyURL *neturl.URL) string {
+	if forwardsHTTP(url, proxyURL) {
+		return url.String()
+	}
+	return url.path
+}
+
+// http1RoundTrip sends a GET request for url over conn and reads the
+// response, returning conn to the keep-alive pool when the server allows.
+func (e *Engine) http1RoundTrip(conn io.ReadWriteCloser, url *URL, proxyURL *neturl.URL, headers map[string]string, trace *requestTrace) (*Response, error) {
+	req := fmt.Sprintf("GET %s HTTP/1.1\r\n", requestTarget(url, proxyURL))
+	for k, v := range headers {
+		req += fmt.Sprintf("%s: %s\r\n", k, v)
+	}
+	if forwardsHTTP(url, proxyURL) {
+		if auth, ok := proxyAuthorization(proxyURL); ok {
+			req += fmt.Sprintf("Proxy-Authorization: %s\r\n", auth)
+		}
+	}
+	req += "\r\n"
+	timings := trace.timings
+	start := time.Now()
+	if _, err := conn.Write([]byte(req)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	timings.Send = time.Since(start)
+
+	// Waiting ends with the first byte of the response; a failure to read
+	// it is reported by readHTTP1Head.
+	start = time.Now()
+	reader := bufio.NewReader(conn)
+	reader.Peek(1)
+	timings.Wait = time.Since(start)
+
+	start = time.Now()
+	budget := &headerBudget{limits: &e.limits}
+	r, err := readHTTP1Head(reader, budget)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	trace.emit(HeadersReceivedEvent{URL: trace.url, StatusCode: r.StatusCode, Proto: r.Proto, Headers: r.Headers})
+	w, err := trace.newBodyWriter(r)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	persistent, err := readHTTP1Body(reader, r, w, budget)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	timings.Receive = time.Since(start)
+	trace.emit(BodyCompleteEvent{URL: trace.url, Bytes: w.n, Duration: timings.Receive})
+
+	// Anything left in the buffer doesn't belong to this response, so the
+	// connection can't be trusted for the next one. A request that asked
+	// to close leaves the server free to, whatever the response says.
+	if persistent && !connectionHas(headers, "close") && reader.Buffered() == 0 {
+		e.mu.Lock()
+		e.connMap[connKey(url, proxyURL)] = &conn
+		e.mu.Unlock()
+	} else {
+		conn.Close()
+	}
+	return r, nil
+}
+
+// readHTTP1Head reads the status line and headers of one response to a
+// GET request, skipping any informational responses before it. All of
+// them count against budget.
+func readHTTP1Head(reader *bufio.Reader, budget *headerBudget) (*Response, error) {
+	for {
+		statusLine, err := readLine(reader, budget)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTP response: no status line: %w", err)
+		}
+		proto, statusCode, reason, err := parseStatusLine(statusLine)
+		if err != nil {
+			return nil, err
+		}
+		headers, err := readHeaders(reader, budget)
+		if err != nil {
+			return nil, err
+		}
+		if statusCode >= 100 && statusCode < 200 && statusCode != 101 {
+			continue
+		}
+		return &Response{
+			StatusCode: statusCode,
+			Proto:      proto,
+			Reason:     reason,
+			Headers:    headers,
+		}, nil
+	}
+}
+
+// readHTTP1Body reads the body of r as framed by its headers into w, with
+// any trailers counting against budget. It also reports whether the
+// connection may carry another request afterwards.
+func readHTTP1Body(reader *bufio.Reader, r *Response, w *bodyWriter, budget *headerBudget) (bool, error) {
+	persistent := isPersistent(r.Proto, r.Headers)
+	switch {
+	case r.StatusCode == 204 || r.StatusCode == 304:
+	case isChunked(r.Headers):
+		if err := readChunkedBody(reader, w, budget); err != nil {
+			return false, err
+		}
+	default:
+		if clStr, ok := getHeader(r.Headers, "Content-Length"); ok {
+			cl, err := strconv.ParseInt(strings.TrimSpace(clStr), 10, 64)
+			if err != nil || cl < 0 {
+				return false, fmt.Errorf("invalid Content-Length: %s", clStr)
+			}
+			if err := w.expect(cl); err != nil {
+				return false, err
+			}
+			if n, err := io.CopyN(w, reader, cl); err != nil {
+				if err == io.EOF && n > 0 {
+					err = io.ErrUnexpectedEOF
+				}
+				return false, fmt.Errorf("reading body: %w", err)
+			}
+		} else {
+			// Without framing the body runs until the server closes the
+			// connection.
+			if _, err := io.Copy(w, reader); err != nil {
+				return false, err
+			}
+			persistent = false
+		}
+	}
+	w.finish(r)
+	return persistent, nil
+}
+
+// parseStatusLine splits a status line such as "HTTP/1.0 404  Not Found"
+// into its parts. Reason phrases are optional and may contain any spacing.
+func parseStatusLine(line string) (string, int, string, error) {
+	proto, rest, _ := strings.Cut(line, " ")
+	if proto != "HTTP/1.0" && proto != "HTTP/1.1" {
+		return "", 0, "", fmt.Errorf("unsupported HTTP version: %q", proto)
+	}
+	rest = strings.TrimLeft(rest, " ")
+	codeStr, reason, _ := strings.Cut(rest, " ")
+	if len(codeStr) != 3 {
+		return "", 0, "", fmt.Errorf("invalid status line: %q", line)
+	}
+	statusCode, err := strconv.Atoi(codeStr)
+	if err != nil || statusCode < 100 {
+		return "", 0, "", fmt.Errorf("invalid status code: %s", codeStr)
+	}
+	return proto, statusCode, strings.TrimSpace(reason), nil
+}
+
+// readHeaders reads header lines up to the blank line ending them. Lines
+// starting with whitespace continue the previous header (obsolete line
+// folding) and repeated headers are joined with commas.
+func readHeaders(reader *bufio.Reader, budget *headerBudget) (map[string]string, error) {
+	headers := make(map[string]string)
+	lastName := ""
+	for {
+		line, err := readLine(reader, budget)
+		if err != nil {
+			if _, ok := err.(*LimitError); ok {
+				return nil, err
+			}
+			return nil, fmt.Errorf("invalid HTTP response: no header end")
+		}
+		if line == "" {
+			return headers, nil
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if lastName == "" {
+				return nil, fmt.Errorf("invalid HTTP response: continuation line without header")
+			}
+			headers[lastName] += " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if err := budget.addField(); err != nil {
+			return nil, err
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if existing, ok := headers[name]; ok {
+			headers[name] = existing + ", " + value
+		} else {
+			headers[name] = value
+		}
+		lastName = name
+	}
+}
+
+// readLine reads a line ending in CRLF, or a bare LF from lenient servers,
+// counting it against budget as it goes so an endless line can't use up
+// memory. A nil budget leaves the line unlimited.
+func readLine(reader *bufio.Reader, budget *headerBudget) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if err := budget.addLine(len(chunk)); err != nil {
+			return "", err
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return string(bytes.TrimSuffix(line, []byte("\r"))), nil
+}
+
+// isPersistent reports whether the connection stays open after a response:
+// HTTP/1.1 connections do unless closed explicitly, HTTP/1.0 ones only
+// when keep-alive was negotiated.
+func isPersistent(proto string, headers map[string]string) bool {
+	if connectionHas(headers, "close") {
+		return false
+	}
+	if proto == "HTTP/1.0" {
+		return connectionHas(headers, "keep-alive")
+	}
+	return true
+}
+
+// connectionHas reports whether the Connection header in headers lists
+// token.
+func connectionHas(headers map[string]string, token string) bool {
+	connection, _ := getHeader(headers, "Connection")
+	for _, t := range strings.Split(connection, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func isChunked(headers map[string]string) bool {
+	transferEncoding, ok := getHeader(headers, "Transfer-Encoding")
+	return ok && strings.Contains(strings.ToLower(transferEncoding), "chunked")
+}
+
+// readChunkedBody reads a chunked body into w, discarding chunk extensions
+// and trailers. Each chunk line may be as long as the headers; trailers
+// count against budget along with them.
+func readChunkedBody(reader *bufio.Reader, w io.Writer, budget *headerBudget) error {
+	for {
+		line, err := readLine(reader, &headerBudget{limits: budget.limits})
+		if err != nil {
+			if _, ok := err.(*LimitError); ok {
+				return err
+			}
+			return fmt.Errorf("invalid chunked encoding")
+		}
+		sizeStr, _, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 64)
+		if err != nil || size < 0 {
+			return fmt.Errorf("invalid chunk size: %q", sizeStr)
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(w, reader, size); err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("chunk size exceeds body length")
+			}
+			return err
+		}
+		if line, err := readLine(reader, &headerBudget{limits: budget.limits}); err != nil || line != "" {
+			return fmt.Errorf("invalid chunked encoding after chunk data")
+		}
+	}
+	for {
+		line, err := readLine(reader, budget)
+		if err != nil {
+			if _, ok := err.(*LimitError); ok {
+				return err
+			}
+			return fmt.Errorf("invalid chunked encoding: missing trailer end")
+		}
+		if line == "" {
+			return nil
+		}
+	}
+}
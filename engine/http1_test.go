@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"bufio"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// serveRawHTTP answers every request read from a connection with the
+// response respond returns, closing the connection when asked to. It
+// counts the connections accepted.
+func serveRawHTTP(t *testing.T, respond func() (string, bool)) (string, *atomic.Int32) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var conns atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					for {
+						line, err := reader.ReadString('\n')
+						if err != nil {
+							return
+						}
+						if line == "\r\n" {
+							break
+						}
+					}
+					response, closeAfter := respond()
+					conn.Write([]byte(response))
+					if closeAfter {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String(), &conns
+}
+
+func TestParseStatusLine(t *testing.T) {
+	tests := []struct {
+		line        string
+		proto       string
+		code        int
+		reason      string
+		expectError bool
+	}{
+		{line: "HTTP/1.1 200 OK", proto: "HTTP/1.1", code: 200, reason: "OK"},
+		{line: "HTTP/1.0 404 Not Found", proto: "HTTP/1.0", code: 404, reason: "Not Found"},
+		{line: "HTTP/1.1  301   Moved   Permanently ", proto: "HTTP/1.1", code: 301, reason: "Moved   Permanently"},
+		{line: "HTTP/1.1 204", proto: "HTTP/1.1", code: 204, reason: ""},
+		{line: "HTTP/2.0 200 OK", expectError: true},
+		{line: "HTTP/1.1 abc OK", expectError: true},
+		{line: "garbage", expectError: true},
+	}
+	for _, test := range tests {
+		proto, code, reason, err := parseStatusLine(test.line)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("expected error for %q", test.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", test.line, err)
+			continue
+		}
+		if proto != test.proto || code != test.code || reason != test.reason {
+			t.Errorf("for %q, expected (%q, %d, %q), got (%q, %d, %q)", test.line, test.proto, test.code, test.reason, proto, code, reason)
+		}
+	}
+}
+
+func TestHTTP10Response(t *testing.T) {
+	addr, _ := serveRawHTTP(t, func() (string, bool) {
+		return "HTTP/1.0 200 Okay  then\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"X-Folded: first\r\n" +
+			"\t second\r\n" +
+			"\r\n" +
+			"read until close", true
+	})
+
+	url, err := Parse("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	response, err := NewEngine().Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if response.Proto != "HTTP/1.0" || response.StatusCode != 200 || response.Reason != "Okay  then" {
+		t.Errorf("unexpected status: %s %d %q", response.Proto, response.StatusCode, response.Reason)
+	}
+	if response.Headers["X-Folded"] != "first second" {
+		t.Errorf("expected folded header to be joined, got %q", response.Headers["X-Folded"])
+	}
+	if string(response.Body) != "read until close" {
+		t.Errorf("expected body up to close, got %q", string(response.Body))
+	}
+}
+
+func TestHTTP1Persistence(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		headers  map[string]string
+		expected int32
+	}{
+		{
+			name:     "HTTP/1.0 without keep-alive",
+			response: "HTTP/1.0 200 OK\r\nContent-Length: 2\r\n\r\nok",
+			expected: 3,
+		},
+		{
+			name:     "HTTP/1.0 with keep-alive",
+			response: "HTTP/1.0 200 OK\r\nConnection: keep-alive\r\nContent-Length: 2\r\n\r\nok",
+			expected: 1,
+		},
+		{
+			name:     "HTTP/1.1 defaults to persistent",
+			response: "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok",
+			expected: 1,
+		},
+		{
+			name:     "HTTP/1.1 with close",
+			response: "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 2\r\n\r\nok",
+			expected: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, conns := serveRawHTTP(t, func() (string, bool) {
+				return tt.response, false
+			})
+			url, err := Parse("http://" + addr + "/")
+			if err != nil {
+				t.Fatalf("failed to parse URL: %v", err)
+			}
+			e := NewEngine()
+			for i := range 3 {
+				response, err := e.Request(url, map[string]string{"Connection": "keep-alive"})
+				if err != nil {
+					t.Fatalf("request %d failed: %v", i, err)
+				}
+				if string(response.Body) != "ok" || response.StatusCode != 200 {
+					t.Fatalf("request %d: unexpected response %d %q", i, response.StatusCode, string(response.Body))
+				}
+				if reused := response.Connection.Reused; reused != (i > 0 && tt.expected == 1) {
+					t.Errorf("request %d: unexpected Reused %v", i, reused)
+				}
+			}
+			if n := conns.Load(); n != tt.expected {
+				t.Errorf("expected %d connections, got %d", tt.expected, n)
+			}
+		})
+	}
+}
+
+func TestHTTP1RequestClose(t *testing.T) {
+	// The server closes the connection as the request asked, without
+	// saying so in the response.
+	addr, conns := serveRawHTTP(t, func() (string, bool) {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", true
+	})
+	url, err := Parse("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	e := NewEngine(WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	for i := range 3 {
+		response, err := e.Request(url, nil)
+		if err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+		if string(response.Body) != "ok" || response.Connection.Reused {
+			t.Errorf("request %d: expected %q on a new connection, got %q, reused %v", i, "ok", response.Body, response.Connection.Reused)
+		}
+	}
+	if n := conns.Load(); n != 3 {
+		t.Errorf("expected 3 connections, got %d", n)
+	}
+}
@@ -0,0 +1,717 @@
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTP/2 (RFC 9113) client connections, negotiated through ALPN on https.
+
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const (
+	http2FrameData         = 0x0
+	http2FrameHeaders      = 0x1
+	http2FramePriority     = 0x2
+	http2FrameRSTStream    = 0x3
+	http2FrameSettings     = 0x4
+	http2FramePushPromise  = 0x5
+	http2FramePing         = 0x6
+	http2FrameGoAway       = 0x7
+	http2FrameWindowUpdate = 0x8
+	http2FrameContinuation = 0x9
+)
+
+const (
+	http2FlagEndStream  = 0x1
+	http2FlagAck        = 0x1
+	http2FlagEndHeaders = 0x4
+	http2FlagPadded     = 0x8
+	http2FlagPriority   = 0x20
+)
+
+const (
+	http2SettingHeaderTableSize      = 0x1
+	http2SettingEnablePush           = 0x2
+	http2SettingMaxConcurrentStreams = 0x3
+	http2SettingInitialWindowSize    = 0x4
+	http2SettingMaxFrameSize         = 0x5
+	http2SettingMaxHeaderListSize    = 0x6
+)
+
+const (
+	http2ErrProtocol      = 0x1
+	http2ErrFlowControl   = 0x3
+	http2ErrFrameSize     = 0x6
+	http2ErrRefusedStream = 0x7
+	http2ErrCancel        = 0x8
+	http2ErrCompression   = 0x9
+)
+
+const (
+	http2DefaultMaxFrameSize = 16384
+	http2DefaultWindowSize   = 65535
+
+	// The windows we advertise for receiving response bodies.
+	http2StreamWindowSize = 1 << 20
+	http2ConnWindowSize   = 1 << 24
+)
+
+// errHTTP2ConnClosed is returned for requests that couldn't start because
+// the connection went away; they are safe to retry on a new connection.
+var errHTTP2ConnClosed = errors.New("http2: connection is no longer usable")
+
+type http2ConnError struct {
+	code   uint32
+	reason string
+}
+
+func (e http2ConnError) Error() string {
+	return fmt.Sprintf("http2: connection error %d: %s", e.code, e.reason)
+}
+
+type http2Frame struct {
+	typ      byte
+	flags    byte
+	streamID uint32
+	payload  []byte
+}
+
+type http2Stream struct {
+	id         uint32
+	status     int
+	headers    map[string]string
+	body       *bodyWriter
+	recvWindow int32
+	done       chan struct{}
+	err        error
+	// firstByte is when the first response headers arrived.
+	firstByte time.Time
+	trace     *requestTrace
+}
+
+// http2ClientConn multiplexes requests over one HTTP/2 connection. A
+// background goroutine reads frames and hands them to their streams.
+type http2ClientConn struct {
+	conn   io.ReadWriteCloser
+	wmu    sync.Mutex // serializes frame writes
+	limits *Limits
+	// onClose is called once the connection has been torn down.
+	onClose func(cc *http2ClientConn)
+
+	mu                   sync.Mutex
+	cond                 *sync.Cond
+	streams              map[uint32]*http2Stream
+	reserved             int
+	nextStreamID         uint32
+	maxConcurrentStreams uint32
+	peerMaxFrameSize     uint32
+	recvWindow           int32
+	goAway               bool
+	err                  error
+
+	// Only touched by the read loop.
+	dec             *hpackDecoder
+	headerStream    uint32
+	headerBlock     []byte
+	headerEndStream bool
+}
+
+// newHTTP2ClientConn sends the connection preface and starts reading
+// frames from conn. Responses on it are held to limits, and onClose is
+// called when the connection goes away.
+func newHTTP2ClientConn(conn io.ReadWriteCloser, limits *Limits, onClose func(cc *http2ClientConn)) (*http2ClientConn, error) {
+	cc := &http2ClientConn{
+		conn:                 conn,
+		limits:               limits,
+		onClose:              onClose,
+		streams:              make(map[uint32]*http2Stream),
+		nextStreamID:         1,
+		maxConcurrentStreams: 100,
+		peerMaxFrameSize:     http2DefaultMaxFrameSize,
+		recvWindow:           http2ConnWindowSize,
+		dec:                  newHPACKDecoder(),
+	}
+	cc.cond = sync.NewCond(&cc.mu)
+
+	settings := http2AppendSetting(nil, http2SettingEnablePush, 0)
+	settings = http2AppendSetting(settings, http2SettingInitialWindowSize, http2StreamWindowSize)
+	if limits.MaxHeaderBytes > 0 {
+		settings = http2AppendSetting(settings, http2SettingMaxHeaderListSize, uint32(limits.MaxHeaderBytes))
+	}
+	if _, err := conn.Write([]byte(http2Preface)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := cc.writeFrame(http2FrameSettings, 0, 0, settings); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := cc.writeWindowUpdate(0, http2ConnWindowSize-http2DefaultWindowSize); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go cc.readLoop()
+	return cc, nil
+}
+
+// canTakeNewRequest reports whether new streams may still be opened.
+func (cc *http2ClientConn) canTakeNewRequest() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.err == nil && !cc.goAway
+}
+
+// roundTrip sends a GET request for url on a new stream and waits for the
+// complete response.
+func (cc *http2ClientConn) roundTrip(url *URL, headers map[string]string, trace *requestTrace) (*Response, error) {
+	fields := []hpackField{
+		{":method", "GET"},
+		{":scheme", url.scheme},
+		{":authority", url.host},
+		{":path", url.path},
+	}
+	for k, v := range headers {
+		name := strings.ToLower(k)
+		switch name {
+		case "host", "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			continue
+		case "te":
+			if v != "trailers" {
+				continue
+			}
+		}
+		fields = append(fields, hpackField{name, v})
+	}
+	block := hpackEncode(fields)
+
+	cc.mu.Lock()
+	for cc.err == nil && !cc.goAway && len(cc.streams)+cc.reserved >= int(cc.maxConcurrentStreams) {
+		cc.cond.Wait()
+	}
+	if cc.err != nil || cc.goAway {
+		cc.mu.Unlock()
+		return nil, errHTTP2ConnClosed
+	}
+	cc.reserved++
+	cc.mu.Unlock()
+
+	// Streams have to be opened in increasing order, so the ID is picked
+	// while holding the write lock.
+	cc.wmu.Lock()
+	cc.mu.Lock()
+	cc.reserved--
+	if cc.err != nil || cc.goAway {
+		cc.mu.Unlock()
+		cc.wmu.Unlock()
+		cc.cond.Broadcast()
+		return nil, errHTTP2ConnClosed
+	}
+	stream := &http2Stream{
+		id:         cc.nextStreamID,
+		recvWindow: http2StreamWindowSize,
+		body:       &bodyWriter{},
+		done:       make(chan struct{}),
+		trace:      trace,
+	}
+	cc.nextStreamID += 2
+	cc.streams[stream.id] = stream
+	maxFrameSize := int(cc.peerMaxFrameSize)
+	cc.mu.Unlock()
+	start := time.Now()
+	err := cc.writeHeaders(stream.id, block, maxFrameSize)
+	cc.wmu.Unlock()
+	if err != nil {
+		cc.fail(err)
+	}
+	sent := time.Now()
+	timings := trace.timings
+	timings.Send = sent.Sub(start)
+
+	<-stream.done
+	if stream.err != nil {
+		return nil, stream.err
+	}
+	if !stream.firstByte.IsZero() {
+		timings.Wait = stream.firstByte.Sub(sent)
+		timings.Receive = time.Since(stream.firstByte)
+	}
+	trace.emit(BodyCompleteEvent{URL: trace.url, Bytes: stream.body.n, Duration: timings.Receive})
+	r := &Response{
+		StatusCode: stream.status,
+		Proto:      "HTTP/2.0",
+		Headers:    stream.headers,
+	}
+	stream.body.finish(r)
+	return r, nil
+}
+
+// writeHeaders writes a header block as a HEADERS frame followed by as
+// many CONTINUATION frames as needed. The caller holds wmu.
+func (cc *http2ClientConn) writeHeaders(streamID uint32, block []byte, maxFrameSize int) error {
+	typ := byte(http2FrameHeaders)
+	flags := byte(http2FlagEndStream)
+	for first := true; first || len(block) > 0; first = false {
+		chunk := block
+		if len(chunk) > maxFrameSize {
+			chunk = chunk[:maxFrameSize]
+		}
+		block = block[len(chunk):]
+		if len(block) == 0 {
+			flags |= http2FlagEndHeaders
+		}
+		if err := cc.writeFrameLocked(typ, flags, streamID, chunk); err != nil {
+			return err
+		}
+		typ, flags = http2FrameContinuation, 0
+	}
+	return nil
+}
+
+func (cc *http2ClientConn) writeFrame(typ, flags byte, streamID uint32, payload []byte) error {
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+	return cc.writeFrameLocked(typ, flags, streamID, payload)
+}
+
+func (cc *http2ClientConn) writeFrameLocked(typ, flags byte, streamID uint32, payload []byte) error {
+	frame := make([]byte, 9, 9+len(payload))
+	frame[0] = byte(len(payload) >> 16)
+	frame[1] = byte(len(payload) >> 8)
+	frame[2] = byte(len(payload))
+	frame[3] = typ
+	frame[4] = flags
+	binary.BigEndian.PutUint32(frame[5:], streamID&0x7fffffff)
+	frame = append(frame, payload...)
+	_, err := cc.conn.Write(frame)
+	return err
+}
+
+func (cc *http2ClientConn) writeWindowUpdate(streamID uint32, increment uint32) error {
+	return cc.writeFrame(http2FrameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, increment))
+}
+
+func http2AppendSetting(dst []byte, id uint16, value uint32) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, id)
+	return binary.BigEndian.AppendUint32(dst, value)
+}
+
+func (cc *http2ClientConn) readFrame() (*http2Frame, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(cc.conn, header); err != nil {
+		return nil, err
+	}
+	length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+	if length > http2DefaultMaxFrameSize {
+		return nil, http2ConnError{http2ErrFrameSize, "frame larger than SETTINGS_MAX_FRAME_SIZE"}
+	}
+	frame := &http2Frame{
+		typ:      header[3],
+		flags:    header[4],
+		streamID: binary.BigEndian.Uint32(header[5:]) & 0x7fffffff,
+		payload:  make([]byte, length),
+	}
+	if _, err := io.ReadFull(cc.conn, frame.payload); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (cc *http2ClientConn) readLoop() {
+	for {
+		frame, err := cc.readFrame()
+		if err == nil {
+			err = cc.handleFrame(frame)
+		}
+		if err != nil {
+			var connErr http2ConnError
+			if errors.As(err, &connErr) {
+				// The server never opens streams towards us, so the last
+				// stream we processed is always 0.
+				payload := binary.BigEndian.AppendUint32(nil, 0)
+				payload = binary.BigEndian.AppendUint32(payload, connErr.code)
+				cc.writeFrame(http2FrameGoAway, 0, 0, payload)
+			}
+			cc.fail(err)
+			return
+		}
+	}
+}
+
+func (cc *http2ClientConn) handleFrame(frame *http2Frame) error {
+	if cc.headerStream != 0 && frame.typ != http2FrameContinuation {
+		return http2ConnError{http2ErrProtocol, "expected CONTINUATION frame"}
+	}
+
+	switch frame.typ {
+	case http2FrameData:
+		return cc.handleData(frame)
+	case http2FrameHeaders:
+		payload, err := http2StripPadding(frame)
+		if err != nil {
+			return err
+		}
+		if frame.flags&http2FlagPriority != 0 {
+			if len(payload) < 5 {
+				return http2ConnError{http2ErrFrameSize, "HEADERS frame too short"}
+			}
+			payload = payload[5:]
+		}
+		if frame.streamID == 0 {
+			return http2ConnError{http2ErrProtocol, "HEADERS on stream 0"}
+		}
+		cc.headerStream = frame.streamID
+		cc.headerBlock = append(cc.headerBlock[:0], payload...)
+		cc.headerEndStream = frame.flags&http2FlagEndStream != 0
+		if err := cc.checkHeaderBlock(); err != nil {
+			return err
+		}
+		if frame.flags&http2FlagEndHeaders != 0 {
+			return cc.handleHeaderBlock()
+		}
+	case http2FrameContinuation:
+		if frame.streamID != cc.headerStream || cc.headerStream == 0 {
+			return http2ConnError{http2ErrProtocol, "unexpected CONTINUATION frame"}
+		}
+		cc.headerBlock = append(cc.headerBlock, frame.payload...)
+		if err := cc.checkHeaderBlock(); err != nil {
+			return err
+		}
+		if frame.flags&http2FlagEndHeaders != 0 {
+			return cc.handleHeaderBlock()
+		}
+	case http2FrameRSTStream:
+		if len(frame.payload) != 4 {
+			return http2ConnError{http2ErrFrameSize, "RST_STREAM frame with wrong size"}
+		}
+		code := binary.BigEndian.Uint32(frame.payload)
+		err := fmt.Errorf("http2: stream %d reset by server with code %d", frame.streamID, code)
+		if code == http2ErrRefusedStream {
+			err = errHTTP2ConnClosed
+		}
+		cc.finishStream(frame.streamID, err)
+	case http2FrameSettings:
+		return cc.handleSettings(frame)
+	case http2FramePushPromise:
+		return http2ConnError{http2ErrProtocol, "PUSH_PROMISE with push disabled"}
+	case http2FramePing:
+		if len(frame.payload) != 8 {
+			return http2ConnError{http2ErrFrameSize, "PING frame with wrong size"}
+		}
+		if frame.flags&http2FlagAck == 0 {
+			return cc.writeFrame(http2FramePing, http2FlagAck, 0, frame.payload)
+		}
+	case http2FrameGoAway:
+		if len(frame.payload) < 8 {
+			return http2ConnError{http2ErrFrameSize, "GOAWAY frame too short"}
+		}
+		lastStreamID := binary.BigEndian.Uint32(frame.payload) & 0x7fffffff
+		cc.mu.Lock()
+		cc.goAway = true
+		var unprocessed []uint32
+		for id := range cc.streams {
+			if id > lastStreamID {
+				unprocessed = append(unprocessed, id)
+			}
+		}
+		cc.mu.Unlock()
+		cc.cond.Broadcast()
+		for _, id := range unprocessed {
+			cc.finishStream(id, errHTTP2ConnClosed)
+		}
+		cc.closeIfDrained()
+	case http2FrameWindowUpdate:
+		// We never send request bodies, so our send windows don't matter.
+		if len(frame.payload) != 4 {
+			return http2ConnError{http2ErrFrameSize, "WINDOW_UPDATE frame with wrong size"}
+		}
+	}
+	return nil
+}
+
+func (cc *http2ClientConn) handleData(frame *http2Frame) error {
+	if frame.streamID == 0 {
+		return http2ConnError{http2ErrProtocol, "DATA on stream 0"}
+	}
+	payload, err := http2StripPadding(frame)
+	if err != nil {
+		return err
+	}
+
+	// Padding counts against flow control too.
+	size := int32(len(frame.payload))
+	cc.mu.Lock()
+	cc.recvWindow -= size
+	if cc.recvWindow < 0 {
+		cc.mu.Unlock()
+		return http2ConnError{http2ErrFlowControl, "connection window exceeded"}
+	}
+	var connIncrement int32
+	if cc.recvWindow < http2ConnWindowSize/2 {
+		connIncrement = http2ConnWindowSize - cc.recvWindow
+		cc.recvWindow = http2ConnWindowSize
+	}
+	stream := cc.streams[frame.streamID]
+	var streamIncrement int32
+	var body *bodyWriter
+	if stream != nil {
+		stream.recvWindow -= size
+		if stream.recvWindow < 0 {
+			cc.mu.Unlock()
+			return http2ConnError{http2ErrFlowControl, "stream window exceeded"}
+		}
+		if frame.flags&http2FlagEndStream == 0 && stream.recvWindow < http2StreamWindowSize/2 {
+			streamIncrement = http2StreamWindowSize - stream.recvWindow
+			stream.recvWindow = http2StreamWindowSize
+		}
+		body = stream.body
+	}
+	cc.mu.Unlock()
+
+	if body != nil {
+		if _, err := body.Write(payload); err != nil {
+			return cc.cancelStream(frame.streamID, err)
+		}
+	}
+
+	if connIncrement > 0 {
+		if err := cc.writeWindowUpdate(0, uint32(connIncrement)); err != nil {
+			return err
+		}
+	}
+	if streamIncrement > 0 {
+		if err := cc.writeWindowUpdate(frame.streamID, uint32(streamIncrement)); err != nil {
+			return err
+		}
+	}
+	if frame.flags&http2FlagEndStream != 0 {
+		cc.finishStream(frame.streamID, nil)
+	}
+	return nil
+}
+
+// handleHeaderBlock decodes a complete header block. Every block has to be
+// decoded, even for streams we no longer track, to keep the HPACK dynamic
+// table in sync with the server.
+func (cc *http2ClientConn) handleHeaderBlock() error {
+	streamID := cc.headerStream
+	endStream := cc.headerEndStream
+	cc.headerStream = 0
+
+	fields, err := cc.dec.decode(cc.headerBlock)
+	if err != nil {
+		return http2ConnError{http2ErrCompression, err.Error()}
+	}
+
+	cc.mu.Lock()
+	stream := cc.streams[streamID]
+	cc.mu.Unlock()
+	if stream == nil {
+		return nil
+	}
+	if err := cc.checkHeaderList(fields); err != nil {
+		return cc.cancelStream(streamID, err)
+	}
+	if stream.firstByte.IsZero() {
+		stream.firstByte = time.Now()
+	}
+
+	if stream.status == 0 {
+		status := 0
+		headers := make(map[string]string)
+		for _, field := range fields {
+			if field.name == ":status" {
+				status, err = strconv.Atoi(field.value)
+				if err != nil {
+					cc.finishStream(streamID, fmt.Errorf("http2: invalid status %q", field.value))
+					return nil
+				}
+				continue
+			}
+			if strings.HasPrefix(field.name, ":") {
+				continue
+			}
+			key := textproto.CanonicalMIMEHeaderKey(field.name)
+			if existing, ok := headers[key]; ok {
+				headers[key] = existing + ", " + field.value
+			} else {
+				headers[key] = field.value
+			}
+		}
+		if status == 0 {
+			cc.finishStream(streamID, errors.New("http2: response without :status"))
+			return nil
+		}
+		// Informational responses are followed by the real one.
+		if status >= 100 && status < 200 {
+			return nil
+		}
+		stream.status = status
+		stream.headers = headers
+		stream.trace.emit(HeadersReceivedEvent{URL: stream.trace.url, StatusCode: status, Proto: "HTTP/2.0", Headers: headers})
+		body, err := stream.trace.newBodyWriter(&Response{StatusCode: status, Proto: "HTTP/2.0", Headers: headers})
+		if err != nil {
+			return cc.cancelStream(streamID, err)
+		}
+		cc.mu.Lock()
+		stream.body = body
+		cc.mu.Unlock()
+	}
+
+	if endStream {
+		cc.finishStream(streamID, nil)
+	}
+	return nil
+}
+
+func (cc *http2ClientConn) handleSettings(frame *http2Frame) error {
+	if frame.streamID != 0 {
+		return http2ConnError{http2ErrProtocol, "SETTINGS on a stream"}
+	}
+	if frame.flags&http2FlagAck != 0 {
+		return nil
+	}
+	if len(frame.payload)%6 != 0 {
+		return http2ConnError{http2ErrFrameSize, "SETTINGS frame with wrong size"}
+	}
+
+	cc.mu.Lock()
+	for p := frame.payload; len(p) > 0; p = p[6:] {
+		id := binary.BigEndian.Uint16(p)
+		value := binary.BigEndian.Uint32(p[2:])
+		switch id {
+		case http2SettingMaxConcurrentStreams:
+			cc.maxConcurrentStreams = value
+		case http2SettingMaxFrameSize:
+			if value < http2DefaultMaxFrameSize || value > 1<<24-1 {
+				cc.mu.Unlock()
+				return http2ConnError{http2ErrProtocol, "invalid SETTINGS_MAX_FRAME_SIZE"}
+			}
+			cc.peerMaxFrameSize = value
+		case http2SettingInitialWindowSize:
+			if value > 1<<31-1 {
+				cc.mu.Unlock()
+				return http2ConnError{http2ErrFlowControl, "invalid SETTINGS_INITIAL_WINDOW_SIZE"}
+			}
+		}
+		// SETTINGS_HEADER_TABLE_SIZE needs no action since our encoder
+		// never adds to the server's dynamic table.
+	}
+	cc.mu.Unlock()
+	cc.cond.Broadcast()
+
+	return cc.writeFrame(http2FrameSettings, http2FlagAck, 0, nil)
+}
+
+func (cc *http2ClientConn) finishStream(id uint32, err error) {
+	cc.mu.Lock()
+	stream, ok := cc.streams[id]
+	if ok {
+		delete(cc.streams, id)
+	}
+	cc.mu.Unlock()
+	if !ok {
+		return
+	}
+	if err == nil && stream.status == 0 {
+		err = errors.New("http2: stream ended without a response")
+	}
+	stream.err = err
+	close(stream.done)
+	cc.cond.Broadcast()
+	cc.closeIfDrained()
+}
+
+// closeIfDrained closes a connection the server sent GOAWAY on once its
+// last stream is done, since it can't take any new ones.
+func (cc *http2ClientConn) closeIfDrained() {
+	cc.mu.Lock()
+	drained := cc.goAway && cc.err == nil && len(cc.streams) == 0
+	cc.mu.Unlock()
+	if drained {
+		cc.fail(errHTTP2ConnClosed)
+	}
+}
+
+// checkHeaderBlock fails the connection once a header block being
+// received grows past the header limit. The block can't just be dropped,
+// since the HPACK state it carries is shared by every stream.
+func (cc *http2ClientConn) checkHeaderBlock() error {
+	if cc.limits.MaxHeaderBytes > 0 && len(cc.headerBlock) > cc.limits.MaxHeaderBytes {
+		return &LimitError{Limit: "MaxHeaderBytes", Max: int64(cc.limits.MaxHeaderBytes)}
+	}
+	return nil
+}
+
+// checkHeaderList holds a decoded header block to the header limits,
+// sizing fields as SETTINGS_MAX_HEADER_LIST_SIZE does. It runs before the
+// fields are joined into a map, since repeated references to the dynamic
+// table can decode to far more than the block's own size.
+func (cc *http2ClientConn) checkHeaderList(fields []hpackField) error {
+	budget := &headerBudget{limits: cc.limits}
+	for _, field := range fields {
+		if err := budget.addLine(field.size()); err != nil {
+			return err
+		}
+		if strings.HasPrefix(field.name, ":") {
+			continue
+		}
+		if err := budget.addField(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancelStream fails the stream id with err and tells the server to stop
+// sending it.
+func (cc *http2ClientConn) cancelStream(id uint32, err error) error {
+	cc.finishStream(id, err)
+	return cc.writeFrame(http2FrameRSTStream, 0, id, binary.BigEndian.AppendUint32(nil, http2ErrCancel))
+}
+
+// fail tears the connection down and fails every open stream with err.
+func (cc *http2ClientConn) fail(err error) {
+	cc.mu.Lock()
+	first := cc.err == nil
+	if first {
+		cc.err = err
+	}
+	streams := cc.streams
+	cc.streams = make(map[uint32]*http2Stream)
+	cc.mu.Unlock()
+	if first {
+		cc.conn.Close()
+		if cc.onClose != nil {
+			cc.onClose(cc)
+		}
+	}
+	for _, stream := range streams {
+		stream.err = fmt.Errorf("http2: connection failed: %w", err)
+		close(stream.done)
+	}
+	cc.cond.Broadcast()
+}
+
+func http2StripPadding(frame *http2Frame) ([]byte, error) {
+	payload := frame.payload
+	if frame.flags&http2FlagPadded == 0 {
+		return payload, nil
+	}
+	if len(payload) == 0 {
+		return nil, http2ConnError{http2ErrProtocol, "padded frame without pad length"}
+	}
+	padLength := int(payload[0])
+	if padLength >= len(payload) {
+		return nil, http2ConnError{http2ErrProtocol, "padding exceeds frame"}
+	}
+	return payload[1 : len(payload)-padLength], nil
+}
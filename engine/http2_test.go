@@ -0,0 +1,354 @@
+package engine
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHPACKDecode(t *testing.T) {
+	// Request examples with Huffman coding from RFC 7541, Appendix C.4.
+	blocks := []struct {
+		hex      string
+		expected []hpackField
+	}{
+		{
+			"828684418cf1e3c2e5f23a6ba0ab90f4ff",
+			[]hpackField{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}},
+		},
+		{
+			"828684be5886a8eb10649cbf",
+			[]hpackField{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}, {"cache-control", "no-cache"}},
+		},
+		{
+			"828785bf408825a849e95ba97d7f8925a849e95bb8e8b4bf",
+			[]hpackField{{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"}, {":authority", "www.example.com"}, {"custom-key", "custom-value"}},
+		},
+	}
+
+	d := newHPACKDecoder()
+	for i, block := range blocks {
+		data, _ := hex.DecodeString(block.hex)
+		fields, err := d.decode(data)
+		if err != nil {
+			t.Fatalf("block %d: decode failed: %v", i, err)
+		}
+		if fmt.Sprint(fields) != fmt.Sprint(block.expected) {
+			t.Errorf("block %d: expected %v, got %v", i, block.expected, fields)
+		}
+	}
+
+	fields := []hpackField{{":method", "GET"}, {":path", "/a/long/path"}, {"user-agent", "test"}, {"x-custom", "value"}}
+	decoded, err := newHPACKDecoder().decode(hpackEncode(fields))
+	if err != nil {
+		t.Fatalf("failed to decode encoded block: %v", err)
+	}
+	if fmt.Sprint(decoded) != fmt.Sprint(fields) {
+		t.Errorf("expected round trip to give %v, got %v", fields, decoded)
+	}
+}
+
+func newHTTP2Server(t *testing.T, handler http.Handler) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var conns atomic.Int32
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.StartTLS()
+	return server, &conns
+}
+
+func TestHTTP2Multiplexing(t *testing.T) {
+	server, conns := newHTTP2Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Proto, r.URL.Path)
+	}))
+	defer server.Close()
+
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tlsConfig.NextProtos = nil
+	e := NewEngine(WithTLSConfig(tlsConfig))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			path := fmt.Sprintf("/resource/%d", i)
+			url, err := Parse(server.URL + path)
+			if err != nil {
+				errs <- err
+				return
+			}
+			response, err := e.Request(url, nil)
+			if err != nil {
+				errs <- fmt.Errorf("request for %s failed: %v", path, err)
+				return
+			}
+			if string(response.Body) != "HTTP/2.0 "+path {
+				errs <- fmt.Errorf("for %s, got %q", path, string(response.Body))
+				return
+			}
+			if response.TLS.NegotiatedProtocol != "h2" {
+				errs <- fmt.Errorf("expected h2 to be negotiated, got %q", response.TLS.NegotiatedProtocol)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if n := conns.Load(); n != 1 {
+		t.Errorf("expected all requests to share one connection, got %d", n)
+	}
+}
+
+func TestHTTP2LargeAndEncodedBodies(t *testing.T) {
+	big := strings.Repeat("0123456789abcdef", 256*1024) // 4 MiB, beyond the flow control windows
+	server, _ := newHTTP2Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/big":
+			w.Header().Set("X-Long-Header", strings.Repeat("v", 20000))
+			fmt.Fprint(w, big)
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte("Hello, Gzip over h2!"))
+			gz.Close()
+		case "/redirect":
+			http.Redirect(w, r, "/gzip", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tlsConfig.NextProtos = nil
+	e := NewEngine(WithTLSConfig(tlsConfig))
+
+	url, err := Parse(server.URL + "/big")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	response, err := e.Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if len(response.Body) != len(big) || string(response.Body) != big {
+		t.Errorf("expected %d byte body, got %d bytes", len(big), len(response.Body))
+	}
+	if len(response.Headers["X-Long-Header"]) != 20000 {
+		t.Errorf("expected the long header to survive CONTINUATION frames")
+	}
+
+	url, err = Parse(server.URL + "/redirect")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	response, err = e.Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if string(response.Body) != "Hello, Gzip over h2!" || response.URL != server.URL+"/gzip" {
+		t.Errorf("expected decoded body from %s/gzip, got %q from %s", server.URL, string(response.Body), response.URL)
+	}
+	if !response.Connection.Reused {
+		t.Errorf("expected the redirect to reuse the HTTP/2 connection")
+	}
+}
+
+// writeHTTP2Frame writes a frame as a server would.
+func writeHTTP2Frame(t *testing.T, w io.Writer, typ byte, streamID uint32, payload []byte) {
+	t.Helper()
+	header := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), typ, 0}
+	header = binary.BigEndian.AppendUint32(header, streamID)
+	if _, err := w.Write(append(header, payload...)); err != nil {
+		t.Fatalf("failed to write frame: %v", err)
+	}
+}
+
+func TestHTTP2GoAwayClosesDrainedConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+
+	closed := make(chan struct{})
+	cc, err := newHTTP2ClientConn(client, &DEFAULT_LIMITS, func(*http2ClientConn) { close(closed) })
+	if err != nil {
+		t.Fatalf("failed to start connection: %v", err)
+	}
+	stream := &http2Stream{id: 1, body: &bodyWriter{}, done: make(chan struct{})}
+	cc.mu.Lock()
+	cc.streams[1] = stream
+	cc.nextStreamID = 3
+	cc.mu.Unlock()
+
+	// Stream 1 was processed, so it may still finish.
+	goAway := binary.BigEndian.AppendUint32(nil, 1)
+	goAway = binary.BigEndian.AppendUint32(goAway, 0)
+	writeHTTP2Frame(t, server, http2FrameGoAway, 0, goAway)
+	select {
+	case <-closed:
+		t.Fatalf("expected the connection to stay open for stream 1")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if cc.canTakeNewRequest() {
+		t.Errorf("expected no new requests after GOAWAY")
+	}
+
+	writeHTTP2Frame(t, server, http2FrameRSTStream, 1, binary.BigEndian.AppendUint32(nil, http2ErrCancel))
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the connection to close once its last stream was done")
+	}
+	<-stream.done
+}
+
+func TestHTTP2GoAwayRetriesOnNewConn(t *testing.T) {
+	certServer, _ := newHTTP2Server(t, http.NotFoundHandler())
+	defer certServer.Close()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certServer.TLS.Certificates, NextProtos: []string{"h2"}})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	// The first connection sends GOAWAY as the request arrives, before it
+	// processes any stream; the next one answers.
+	var conns atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			goAway := conns.Add(1) == 1
+			go func() {
+				defer conn.Close()
+				frame := func(typ, flags byte, streamID uint32, payload []byte) {
+					header := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), typ, flags}
+					conn.Write(append(binary.BigEndian.AppendUint32(header, streamID), payload...))
+				}
+				if _, err := io.ReadFull(conn, make([]byte, len(http2Preface))); err != nil {
+					return
+				}
+				frame(http2FrameSettings, 0, 0, nil)
+				for {
+					header := make([]byte, 9)
+					if _, err := io.ReadFull(conn, header); err != nil {
+						return
+					}
+					size := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+					if _, err := io.ReadFull(conn, make([]byte, size)); err != nil {
+						return
+					}
+					if header[3] != http2FrameHeaders {
+						continue
+					}
+					streamID := binary.BigEndian.Uint32(header[5:]) & 0x7fffffff
+					if goAway {
+						frame(http2FrameGoAway, 0, 0, make([]byte, 8))
+						continue
+					}
+					// 0x88 is :status 200 from the static table.
+					frame(http2FrameHeaders, http2FlagEndHeaders, streamID, []byte{0x88})
+					frame(http2FrameData, http2FlagEndStream, streamID, []byte("retried"))
+				}
+			}()
+		}
+	}()
+
+	tlsConfig := certServer.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tlsConfig.NextProtos = nil
+	e := NewEngine(WithTLSConfig(tlsConfig), WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour, MaxDelay: time.Hour}))
+	url, err := Parse("https://" + listener.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	response, err := e.Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if string(response.Body) != "retried" || response.Proto != "HTTP/2.0" {
+		t.Errorf("expected %q over HTTP/2, got %s %q", "retried", response.Proto, response.Body)
+	}
+	if n := conns.Load(); n != 2 {
+		t.Errorf("expected the request to be retried on a second connection, got %d connections", n)
+	}
+}
+
+func TestEngineClose(t *testing.T) {
+	h2Server, _ := newHTTP2Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "h2")
+	}))
+	defer h2Server.Close()
+	h1Server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "h1")
+	}))
+	defer h1Server.Close()
+
+	transport := &recordingTransport{}
+	tlsConfig := h2Server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tlsConfig.NextProtos = nil
+	e := NewEngine(WithTLSConfig(tlsConfig), WithTransport(transport))
+	for _, rawURL := range []string{h1Server.URL, h2Server.URL} {
+		url, err := Parse(rawURL + "/")
+		if err != nil {
+			t.Fatalf("failed to parse URL: %v", err)
+		}
+		if _, err := e.Request(url, map[string]string{"Connection": "keep-alive"}); err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+	}
+	if n := len(e.PooledConnections()); n != 2 {
+		t.Fatalf("expected 2 pooled connections, got %d", n)
+	}
+
+	if err := e.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if n := len(e.PooledConnections()); n != 0 {
+		t.Errorf("expected no pooled connections after Close, got %d", n)
+	}
+	for _, conn := range transport.conns {
+		if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+			t.Errorf("expected the connection to %s to be closed, got %v", conn.RemoteAddr(), err)
+		}
+	}
+}
+
+// recordingTransport dials the network and keeps the connections it made.
+type recordingTransport struct {
+	net.Dialer
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (r *recordingTransport) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := r.Dialer.DialContext(ctx, network, address)
+	if err == nil {
+		r.mu.Lock()
+		r.conns = append(r.conns, conn)
+		r.mu.Unlock()
+	}
+	return conn, err
+}
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"fmt"
+)
+
+// Limits cap how much of a response the engine will read, so a hostile or
+// broken server can't make it use unbounded memory. Zero leaves a limit
+// off.
+type Limits struct {
+	// MaxHeaderBytes caps the status line and headers of a response, or
+	// the encoded and decoded header lists of an HTTP/2 response.
+	MaxHeaderBytes int
+	// MaxHeaderCount caps the number of header fields in a response.
+	MaxHeaderCount int
+	// MaxBodyBytes caps a response body as received, before it is
+	// decompressed. Bodies a download is streaming to disk aren't held in
+	// memory and aren't limited.
+	MaxBodyBytes int64
+	// MaxDecodedBytes caps a body after decompression. Like MaxBodyBytes,
+	// it doesn't apply to downloads streaming to disk.
+	MaxDecodedBytes int64
+	// MaxDecompressionRatio caps how many times larger than the received
+	// body its decompressed form may grow, once that is more than
+	// MIN_RATIO_CHECK_BYTES.
+	MaxDecompressionRatio int
+}
+
+// MIN_RATIO_CHECK_BYTES is how large a decompressed body has to be before
+// its compression ratio is checked, since small bodies of repeated text
+// compress very well without being a threat.
+const MIN_RATIO_CHECK_BYTES = 1 << 20
+
+// DEFAULT_LIMITS are the limits engines start with.
+var DEFAULT_LIMITS = Limits{
+	MaxHeaderBytes:        256 << 10,
+	MaxHeaderCount:        256,
+	MaxBodyBytes:          64 << 20,
+	MaxDecodedBytes:       256 << 20,
+	MaxDecompressionRatio: 100,
+}
+
+// WithLimits replaces the engine's response limits.
+func WithLimits(limits Limits) Option {
+	return func(e *Engine) {
+		e.limits = limits
+	}
+}
+
+// LimitError reports a response that went past one of the engine's
+// Limits.
+type LimitError struct {
+	// Limit names the field of Limits that was exceeded.
+	Limit string
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("response exceeds %s of %d", e.Limit, e.Max)
+}
+
+// headerBudget counts the header bytes and fields of one response against
+// the limits.
+type headerBudget struct {
+	limits *Limits
+	bytes  int
+	count  int
+}
+
+// addLine counts n bytes of a status or header line.
+func (b *headerBudget) addLine(n int) error {
+	if b == nil {
+		return nil
+	}
+	b.bytes += n
+	if b.limits.MaxHeaderBytes > 0 && b.bytes > b.limits.MaxHeaderBytes {
+		return &LimitError{Limit: "MaxHeaderBytes", Max: int64(b.limits.MaxHeaderBytes)}
+	}
+	return nil
+}
+
+// addField counts a header field.
+func (b *headerBudget) addField() error {
+	b.count++
+	if b.limits.MaxHeaderCount > 0 && b.count > b.limits.MaxHeaderCount {
+		return &LimitError{Limit: "MaxHeaderCount", Max: int64(b.limits.MaxHeaderCount)}
+	}
+	return nil
+}
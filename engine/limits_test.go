@@ -0,0 +1,192 @@
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(s))
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+	return buf.String()
+}
+
+func TestLimits(t *testing.T) {
+	manyHeaders := strings.Repeat("X-Header: value\r\n", 20)
+	bomb := gzipped(t, strings.Repeat("\x00", 2<<20))
+	small := gzipped(t, "Hello, World!")
+	limits := Limits{
+		MaxHeaderBytes:        1024,
+		MaxHeaderCount:        10,
+		MaxBodyBytes:          4096,
+		MaxDecodedBytes:       1 << 30,
+		MaxDecompressionRatio: 100,
+	}
+
+	tests := []struct {
+		name     string
+		response string
+		limits   Limits
+		// limit is the Limits field the response is expected to exceed.
+		limit string
+	}{
+		{
+			name:     "Within limits",
+			response: "HTTP/1.1 200 OK\r\nContent-Length: 13\r\n\r\nHello, World!",
+			limits:   limits,
+		},
+		{
+			name:     "Long header",
+			response: "HTTP/1.1 200 OK\r\nX-Big: " + strings.Repeat("a", 2000) + "\r\nContent-Length: 0\r\n\r\n",
+			limits:   limits,
+			limit:    "MaxHeaderBytes",
+		},
+		{
+			name:     "Endless status line",
+			response: "HTTP/1.1 200 " + strings.Repeat("O", 10000),
+			limits:   limits,
+			limit:    "MaxHeaderBytes",
+		},
+		{
+			name:     "Endless informational responses",
+			response: strings.Repeat("HTTP/1.1 100 Continue\r\n\r\n", 100),
+			limits:   limits,
+			limit:    "MaxHeaderBytes",
+		},
+		{
+			name:     "Too many headers",
+			response: "HTTP/1.1 200 OK\r\n" + manyHeaders + "Content-Length: 0\r\n\r\n",
+			limits:   limits,
+			limit:    "MaxHeaderCount",
+		},
+		{
+			name:     "Too many headers, unlimited",
+			response: "HTTP/1.1 200 OK\r\n" + manyHeaders + "Content-Length: 0\r\n\r\n",
+		},
+		{
+			name:     "Content-Length over the limit",
+			response: "HTTP/1.1 200 OK\r\nContent-Length: 1000000\r\n\r\n",
+			limits:   limits,
+			limit:    "MaxBodyBytes",
+		},
+		{
+			name:     "Chunked body over the limit",
+			response: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" + strings.Repeat("400\r\n"+strings.Repeat("x", 1024)+"\r\n", 5) + "0\r\n\r\n",
+			limits:   limits,
+			limit:    "MaxBodyBytes",
+		},
+		{
+			name:     "Long chunk line",
+			response: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n1;" + strings.Repeat("x", 2000) + "\r\nx\r\n0\r\n\r\n",
+			limits:   limits,
+			limit:    "MaxHeaderBytes",
+		},
+		{
+			name:     "Unframed body over the limit",
+			response: "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n" + strings.Repeat("x", 5000),
+			limits:   limits,
+			limit:    "MaxBodyBytes",
+		},
+		{
+			name:     "Small gzip body",
+			response: fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", len(small), small),
+			limits:   limits,
+		},
+		{
+			name:     "Gzip bomb by ratio",
+			response: fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", len(bomb), bomb),
+			limits:   Limits{MaxDecompressionRatio: 100},
+			limit:    "MaxDecompressionRatio",
+		},
+		{
+			name:     "Gzip bomb by size",
+			response: fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", len(bomb), bomb),
+			limits:   Limits{MaxDecodedBytes: 1 << 20},
+			limit:    "MaxDecodedBytes",
+		},
+		{
+			name:     "Gzip bomb, unlimited",
+			response: fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", len(bomb), bomb),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, _ := serveRawHTTP(t, func() (string, bool) {
+				return tt.response, true
+			})
+			url, err := Parse("http://" + addr + "/")
+			if err != nil {
+				t.Fatalf("failed to parse URL: %v", err)
+			}
+			_, err = NewEngine(WithLimits(tt.limits)).Request(url, nil)
+
+			var limitErr *LimitError
+			if tt.limit == "" {
+				if err != nil {
+					t.Fatalf("request failed: %v", err)
+				}
+			} else if !errors.As(err, &limitErr) || limitErr.Limit != tt.limit {
+				t.Fatalf("expected %s to be exceeded, got %v", tt.limit, err)
+			}
+		})
+	}
+}
+
+func TestHTTP2Limits(t *testing.T) {
+	server, conns := newHTTP2Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/headers":
+			for i := range 20 {
+				w.Header().Set(fmt.Sprintf("X-Header-%d", i), "value")
+			}
+		case "/big":
+			fmt.Fprint(w, strings.Repeat("x", 100_000))
+		default:
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer server.Close()
+
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tlsConfig.NextProtos = nil
+	e := NewEngine(WithTLSConfig(tlsConfig), WithLimits(Limits{MaxHeaderCount: 10, MaxBodyBytes: 4096}))
+
+	tests := []struct {
+		path  string
+		limit string
+	}{
+		{"/headers", "MaxHeaderCount"},
+		{"/big", "MaxBodyBytes"},
+		{"/", ""},
+	}
+	for _, tt := range tests {
+		url, err := Parse(server.URL + tt.path)
+		if err != nil {
+			t.Fatalf("failed to parse URL: %v", err)
+		}
+		_, err = e.Request(url, nil)
+		var limitErr *LimitError
+		if tt.limit == "" {
+			if err != nil {
+				t.Errorf("request for %s failed: %v", tt.path, err)
+			}
+		} else if !errors.As(err, &limitErr) || limitErr.Limit != tt.limit {
+			t.Errorf("for %s, expected %s to be exceeded, got %v", tt.path, tt.limit, err)
+		}
+	}
+	// Only the streams were cancelled; the connection carried on.
+	if n := conns.Load(); n != 1 {
+		t.Errorf("expected one connection, got %d", n)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package engine
+
+import "os"
+
+// lockFile is a no-op where flock isn't available; processes sharing a
+// directory then only have atomic file replacement to rely on.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
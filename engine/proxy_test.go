@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.RequestURI, r.Header.Get("Proxy-Authorization"))
+	}))
+	defer proxy.Close()
+
+	e := NewEngine(WithProxy("http://user:secret@" + proxy.Listener.Addr().String()))
+
+	url, err := Parse("http://example.invalid/page")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	response, err := e.Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	expected := "http://example.invalid/page Basic dXNlcjpzZWNyZXQ="
+	if string(response.Body) != expected {
+		t.Errorf("expected %q, got %q", expected, string(response.Body))
+	}
+}
+
+func TestHTTPSProxyConnect(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello through the tunnel")
+	}))
+	defer target.Close()
+
+	var connectTarget, connectAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "expected CONNECT", http.StatusMethodNotAllowed)
+			return
+		}
+		connectTarget = r.Host
+		connectAuth = r.Header.Get("Proxy-Authorization")
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		client, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			io.Copy(upstream, client)
+			upstream.Close()
+		}()
+		io.Copy(client, upstream)
+		client.Close()
+	}))
+	defer proxy.Close()
+
+	e := NewEngine(
+		WithProxy("http://user:secret@"+proxy.Listener.Addr().String()),
+		WithTLSConfig(target.Client().Transport.(*http.Transport).TLSClientConfig),
+	)
+
+	url, err := Parse(target.URL + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	response, err := e.Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	if string(response.Body) != "Hello through the tunnel" {
+		t.Errorf("expected tunnelled response, got %q", string(response.Body))
+	}
+	if connectTarget != strings.TrimPrefix(target.URL, "https://") {
+		t.Errorf("expected CONNECT to %s, got %q", target.URL, connectTarget)
+	}
+	if connectAuth != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("expected proxy credentials on CONNECT, got %q", connectAuth)
+	}
+}
+
+func TestUseProxy(t *testing.T) {
+	tests := []struct {
+		host     string
+		noProxy  string
+		expected bool
+	}{
+		{"example.com:80", "", true},
+		{"localhost:8080", "", false},
+		{"127.0.0.1:443", "", false},
+		{"example.com:80", "*", false},
+		{"example.com:80", "example.com", false},
+		{"api.example.com:443", "example.com", false},
+		{"api.example.com:443", ".example.com", false},
+		{"notexample.com:80", "example.com", true},
+		{"example.com:443", "example.com:80", true},
+		{"10.1.2.3:80", "10.0.0.0/8", false},
+		{"11.1.2.3:80", "10.0.0.0/8", true},
+		{"internal:80", "foo, internal ,bar", false},
+	}
+	for _, test := range tests {
+		if result := useProxy(test.host, test.noProxy); result != test.expected {
+			t.Errorf("useProxy(%q, %q) = %v, expected %v", test.host, test.noProxy, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strconv"
+	"strings"
+)
+
+// ByteRange is a range of bytes to ask for in a Range header. End is
+// inclusive; a negative End runs to the end of the representation and a
+// negative Start asks for the last -Start bytes.
+type ByteRange struct {
+	Start, End int64
+}
+
+func (br ByteRange) String() string {
+	switch {
+	case br.Start < 0:
+		return fmt.Sprintf("-%d", -br.Start)
+	case br.End < 0:
+		return fmt.Sprintf("%d-", br.Start)
+	default:
+		return fmt.Sprintf("%d-%d", br.Start, br.End)
+	}
+}
+
+// RangeHeader formats ranges as the value of a Range header, e.g.
+// "bytes=0-99,200-".
+func RangeHeader(ranges ...ByteRange) string {
+	specs := make([]string, len(ranges))
+	for i, br := range ranges {
+		specs[i] = br.String()
+	}
+	return "bytes=" + strings.Join(specs, ",")
+}
+
+// IfRangeValidator returns the validator to send in an If-Range header when
+// asking for the rest of r: its ETag if that is strong, its Last-Modified
+// date otherwise. Weak ETags can't be used for ranges.
+func IfRangeValidator(r *Response) (string, bool) {
+	if etag, ok := getHeader(r.Headers, "ETag"); ok && !strings.HasPrefix(etag, "W/") {
+		return etag, true
+	}
+	return getHeader(r.Headers, "Last-Modified")
+}
+
+// ContentRange is where a part of a 206 response sits in the whole
+// representation. Size is -1 when the server didn't say.
+type ContentRange struct {
+	Start, End, Size int64
+}
+
+// ParseContentRange parses a Content-Range header such as
+// "bytes 0-99/1234". Unsatisfied ranges ("bytes */1234") come back with
+// Start and End set to -1.
+func ParseContentRange(value string) (ContentRange, error) {
+	unit, rest, ok := strings.Cut(strings.TrimSpace(value), " ")
+	if !ok || unit != "bytes" {
+		return ContentRange{}, fmt.Errorf("invalid Content-Range: %q", value)
+	}
+	span, sizeStr, ok := strings.Cut(rest, "/")
+	if !ok {
+		return ContentRange{}, fmt.Errorf("invalid Content-Range: %q", value)
+	}
+	cr := ContentRange{Start: -1, End: -1, Size: -1}
+	if sizeStr != "*" {
+		size, err := strconv.ParseInt(sizeStr, 10, 64)
+		if err != nil || size < 0 {
+			return ContentRange{}, fmt.Errorf("invalid Content-Range: %q", value)
+		}
+		cr.Size = size
+	}
+	if span == "*" {
+		if cr.Size < 0 {
+			return ContentRange{}, fmt.Errorf("invalid Content-Range: %q", value)
+		}
+		return cr, nil
+	}
+	startStr, endStr, ok := strings.Cut(span, "-")
+	start, err1 := strconv.ParseInt(startStr, 10, 64)
+	end, err2 := strconv.ParseInt(endStr, 10, 64)
+	if !ok || err1 != nil || err2 != nil || start < 0 || end < start || (cr.Size >= 0 && end >= cr.Size) {
+		return ContentRange{}, fmt.Errorf("invalid Content-Range: %q", value)
+	}
+	cr.Start, cr.End = start, end
+	return cr, nil
+}
+
+// Part is one range of a representation from a 206 response.
+type Part struct {
+	ContentRange
+	Headers map[string]string
+	Body    []byte
+}
+
+// Parts splits a 206 response into the ranges it carries, whether it
+// holds a single range or a multipart/byteranges body. A 200 response is
+// one part covering the whole representation.
+func (r *Response) Parts() ([]Part, error) {
+	switch r.StatusCode {
+	case 200:
+		size := int64(len(r.Body))
+		return []Part{{ContentRange{0, size - 1, size}, r.Headers, r.Body}}, nil
+	case 206:
+	default:
+		return nil, fmt.Errorf("no ranges in a %d response", r.StatusCode)
+	}
+
+	contentType, _ := getHeader(r.Headers, "Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/byteranges" {
+		value, _ := getHeader(r.Headers, "Content-Range")
+		cr, err := ParseContentRange(value)
+		if err != nil {
+			return nil, err
+		}
+		if cr.Start < 0 || cr.End-cr.Start+1 != int64(len(r.Body)) {
+			return nil, fmt.Errorf("body doesn't match Content-Range: %q", value)
+		}
+		return []Part{{cr, r.Headers, r.Body}}, nil
+	}
+
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, fmt.Errorf("multipart/byteranges without a boundary")
+	}
+	var parts []Part
+	reader := multipart.NewReader(bytes.NewReader(r.Body), boundary)
+	for {
+		p, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart/byteranges body: %v", err)
+		}
+		body, err := io.ReadAll(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart/byteranges body: %v", err)
+		}
+		headers := make(map[string]string, len(p.Header))
+		for name := range p.Header {
+			headers[name] = p.Header.Get(name)
+		}
+		cr, err := ParseContentRange(headers["Content-Range"])
+		if err != nil {
+			return nil, err
+		}
+		if cr.Start < 0 || cr.End-cr.Start+1 != int64(len(body)) {
+			return nil, fmt.Errorf("part doesn't match Content-Range: %q", headers["Content-Range"])
+		}
+		parts = append(parts, Part{cr, headers, body})
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("multipart/byteranges body without parts")
+	}
+	return parts, nil
+}
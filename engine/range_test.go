@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRangeHeader(t *testing.T) {
+	tests := []struct {
+		ranges   []ByteRange
+		expected string
+	}{
+		{[]ByteRange{{0, 99}}, "bytes=0-99"},
+		{[]ByteRange{{500, -1}}, "bytes=500-"},
+		{[]ByteRange{{-500, 0}}, "bytes=-500"},
+		{[]ByteRange{{0, 0}, {10, 19}}, "bytes=0-0,10-19"},
+	}
+	for _, tt := range tests {
+		if got := RangeHeader(tt.ranges...); got != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, got)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected ContentRange
+		wantErr  bool
+	}{
+		{value: "bytes 0-99/1234", expected: ContentRange{0, 99, 1234}},
+		{value: "bytes 100-199/*", expected: ContentRange{100, 199, -1}},
+		{value: "bytes */1234", expected: ContentRange{-1, -1, 1234}},
+		{value: "bytes 0-1234/1234", wantErr: true},
+		{value: "bytes 99-0/1234", wantErr: true},
+		{value: "bytes */*", wantErr: true},
+		{value: "items 0-9/10", wantErr: true},
+		{value: "bytes 0-9", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseContentRange(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("for %q, expected error %v, got %v", tt.value, tt.wantErr, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("for %q, expected %+v, got %+v", tt.value, tt.expected, got)
+		}
+	}
+}
+
+func TestRangeRequests(t *testing.T) {
+	content := strings.Repeat("0123456789", 10)
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	transport := newPipeTransport(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=60")
+		http.ServeContent(w, r, "data.txt", modified, strings.NewReader(content))
+	}))
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		status   int
+		expected []Part
+	}{
+		{
+			name:     "Single range",
+			headers:  map[string]string{"Range": RangeHeader(ByteRange{10, 19})},
+			status:   206,
+			expected: []Part{{ContentRange: ContentRange{10, 19, 100}, Body: []byte("0123456789")}},
+		},
+		{
+			name:    "Multiple ranges",
+			headers: map[string]string{"Range": RangeHeader(ByteRange{0, 2}, ByteRange{-3, 0})},
+			status:  206,
+			expected: []Part{
+				{ContentRange: ContentRange{0, 2, 100}, Body: []byte("012")},
+				{ContentRange: ContentRange{97, 99, 100}, Body: []byte("789")},
+			},
+		},
+		{
+			name:     "Matching If-Range",
+			headers:  map[string]string{"Range": "bytes=95-", "If-Range": `"v1"`},
+			status:   206,
+			expected: []Part{{ContentRange: ContentRange{95, 99, 100}, Body: []byte("56789")}},
+		},
+		{
+			name:     "Stale If-Range",
+			headers:  map[string]string{"Range": "bytes=95-", "If-Range": `"v0"`},
+			status:   200,
+			expected: []Part{{ContentRange: ContentRange{0, 99, 100}, Body: []byte(content)}},
+		},
+	}
+
+	e := NewEngine(WithTransport(transport), WithHosts(map[string]string{"example.com": "192.0.2.1"}))
+	url, err := Parse("http://example.com/data.txt")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	// A cached full response must not answer the range requests.
+	if _, err := e.Request(url, nil); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := e.Request(url, tt.headers)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if response.StatusCode != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, response.StatusCode)
+			}
+			parts, err := response.Parts()
+			if err != nil {
+				t.Fatalf("failed to split parts: %v", err)
+			}
+			if len(parts) != len(tt.expected) {
+				t.Fatalf("expected %d parts, got %d", len(tt.expected), len(parts))
+			}
+			for i, part := range parts {
+				if part.ContentRange != tt.expected[i].ContentRange || string(part.Body) != string(tt.expected[i].Body) {
+					t.Errorf("expected part %+v %q, got %+v %q", tt.expected[i].ContentRange, tt.expected[i].Body, part.ContentRange, part.Body)
+				}
+			}
+		})
+	}
+
+	// Nor are partial responses cached in place of the full one.
+	response, err := e.Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if string(response.Body) != content {
+		t.Errorf("expected the full body, got %q", response.Body)
+	}
+}
+
+func TestPartsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		response *Response
+	}{
+		{"Not a range response", &Response{StatusCode: 404}},
+		{"Missing Content-Range", &Response{StatusCode: 206, Headers: map[string]string{}, Body: []byte("abc")}},
+		{"Short body", &Response{StatusCode: 206, Headers: map[string]string{"Content-Range": "bytes 0-9/10"}, Body: []byte("abc")}},
+		{"Missing boundary", &Response{StatusCode: 206, Headers: map[string]string{"Content-Type": "multipart/byteranges"}}},
+		{"Empty multipart", &Response{
+			StatusCode: 206,
+			Headers:    map[string]string{"Content-Type": "multipart/byteranges; boundary=x"},
+			Body:       []byte("--x--\r\n"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if parts, err := tt.response.Parts(); err == nil {
+				t.Errorf("expected an error, got %+v", parts)
+			}
+		})
+	}
+}
+
+func TestIfRangeValidator(t *testing.T) {
+	tests := []struct {
+		headers  map[string]string
+		expected string
+		ok       bool
+	}{
+		{map[string]string{"ETag": `"abc"`, "Last-Modified": "Tue, 02 Jan 2024 03:04:05 GMT"}, `"abc"`, true},
+		{map[string]string{"ETag": `W/"abc"`, "Last-Modified": "Tue, 02 Jan 2024 03:04:05 GMT"}, "Tue, 02 Jan 2024 03:04:05 GMT", true},
+		{map[string]string{"ETag": `W/"abc"`}, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := IfRangeValidator(&Response{Headers: tt.headers})
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("for %v, expected %q %v, got %q %v", tt.headers, tt.expected, tt.ok, got, ok)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !plan9
+
+package engine
+
+import "syscall"
+
+// errConnReset is what a connection the peer reset fails with.
+var errConnReset error = syscall.ECONNRESET
+
+// resetErrors are the errors a connection reset or closed by the peer
+// fails with.
+var resetErrors = []error{syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE}
@@ -0,0 +1,11 @@
+package engine
+
+import "errors"
+
+// errConnReset is what a connection the peer reset fails with. Plan 9 has
+// no errno values, so the engine only knows the resets it makes up.
+var errConnReset = errors.New("connection reset by peer")
+
+// resetErrors are the errors a connection reset or closed by the peer
+// fails with.
+var resetErrors = []error{errConnReset}
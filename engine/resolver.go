@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DEFAULT_DNS_TTL is how long answers from resolvers that don't report a
+// TTL, like the system resolver, are cached.
+const DEFAULT_DNS_TTL = 60 * time.Second
+
+// Resolver looks up the addresses of a host name along with how long the
+// answer may be cached.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]netip.Addr, time.Duration, error)
+}
+
+// SystemResolver resolves names the way the operating system does. The
+// system doesn't report TTLs, so every answer gets the same one.
+type SystemResolver struct {
+	// Resolver does the lookups; nil means net.DefaultResolver.
+	Resolver *net.Resolver
+	// TTL is reported for every answer; zero means DEFAULT_DNS_TTL.
+	TTL time.Duration
+}
+
+func (r *SystemResolver) LookupHost(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = DEFAULT_DNS_TTL
+	}
+	addrs, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i, addr := range addrs {
+		addrs[i] = addr.Unmap()
+	}
+	return addrs, ttl, nil
+}
+
+type dnsCacheEntry struct {
+	addrs     []netip.Addr
+	expiresAt time.Time
+}
+
+// CachingResolver remembers the answers of another resolver for as long
+// as their TTL allows. Failed lookups aren't cached.
+type CachingResolver struct {
+	resolver Resolver
+	mu       sync.Mutex
+	entries  map[string]dnsCacheEntry
+}
+
+// NewCachingResolver caches the answers of resolver.
+func NewCachingResolver(resolver Resolver) *CachingResolver {
+	return &CachingResolver{
+		resolver: resolver,
+		entries:  make(map[string]dnsCacheEntry),
+	}
+}
+
+func (r *CachingResolver) LookupHost(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	host = strings.ToLower(host)
+	r.mu.Lock()
+	entry, ok := r.entries[host]
+	if ok && time.Now().After(entry.expiresAt) {
+		delete(r.entries, host)
+		ok = false
+	}
+	r.mu.Unlock()
+	if ok {
+		return entry.addrs, time.Until(entry.expiresAt), nil
+	}
+
+	addrs, ttl, err := r.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	if ttl > 0 {
+		r.mu.Lock()
+		r.entries[host] = dnsCacheEntry{addrs: addrs, expiresAt: time.Now().Add(ttl)}
+		r.mu.Unlock()
+	}
+	return addrs, ttl, nil
+}
+
+// WithResolver makes the engine look up host names with resolver instead
+// of the system resolver. Wrap it in a CachingResolver to keep answers
+// between requests. Engines with a custom Transport only resolve names
+// themselves when given a resolver this way.
+func WithResolver(resolver Resolver) Option {
+	return func(e *Engine) {
+		e.resolver = resolver
+		e.resolveNames = true
+	}
+}
+
+// WithHosts answers lookups for the host names in hosts with the given
+// IP address, like entries in /etc/hosts. It can point production host
+// names at local test servers, whatever Transport the engine uses.
+func WithHosts(hosts map[string]string) Option {
+	return func(e *Engine) {
+		for host, addr := range hosts {
+			e.hosts[strings.ToLower(host)] = addr
+		}
+	}
+}
+
+// resolve looks up host through the hosts overrides and then the
+// engine's resolver, reporting the result to observers.
+func (e *Engine) resolve(ctx context.Context, host string, trace *requestTrace) ([]netip.Addr, error) {
+	start := time.Now()
+	var addrs []netip.Addr
+	var err error
+	if override, ok := e.hosts[strings.ToLower(host)]; ok {
+		var addr netip.Addr
+		if addr, err = netip.ParseAddr(override); err != nil {
+			err = fmt.Errorf("invalid address %q for %s in hosts overrides", override, host)
+		}
+		addrs = []netip.Addr{addr}
+	} else {
+		addrs, _, err = e.resolver.LookupHost(ctx, host)
+		if err == nil && len(addrs) == 0 {
+			err = &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+		}
+	}
+	trace.timings.DNS += time.Since(start)
+
+	event := DNSEvent{URL: trace.url, Host: host, Err: err, Duration: time.Since(start)}
+	if err == nil {
+		for _, addr := range addrs {
+			event.Addrs = append(event.Addrs, addr.String())
+		}
+	}
+	trace.emit(event)
+	if err != nil {
+		return nil, newError(ErrDNS, err)
+	}
+	return addrs, nil
+}
+
+// dialHost connects to hostWithPort, resolving its host name first and
+// racing connections to the addresses it has. Names the engine doesn't
+// resolve itself are passed to the transport as they are.
+func (e *Engine) dialHost(ctx context.Context, hostWithPort string, trace *requestTrace) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(hostWithPort)
+	if err != nil {
+		return nil, err
+	}
+	// Offline, even looking the host up has to fail.
+	if e.conditions != nil && e.conditions.Offline {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: ErrOffline}
+	}
+	if _, err := netip.ParseAddr(host); err == nil || !e.resolvesHost(host) {
+		return e.transport.DialContext(ctx, "tcp", hostWithPort)
+	}
+	addrs, err := e.resolve(ctx, host, trace)
+	if err != nil {
+		return nil, err
+	}
+	return e.dialRace(ctx, addrs, port)
+}
+
+// resolvesHost reports whether the engine looks host up itself. It does
+// for hosts overrides, and otherwise when it dials the network directly or
+// was given a resolver; a custom Transport may not dial real addresses at
+// all, so it gets host names to resolve however it likes.
+func (e *Engine) resolvesHost(host string) bool {
+	if _, ok := e.hosts[strings.ToLower(host)]; ok {
+		return true
+	}
+	return e.resolveNames
+}
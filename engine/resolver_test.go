@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+type countingResolver struct {
+	addrs   []netip.Addr
+	ttl     time.Duration
+	err     error
+	lookups int
+}
+
+func (r *countingResolver) LookupHost(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	r.lookups++
+	return r.addrs, r.ttl, r.err
+}
+
+func TestCachingResolver(t *testing.T) {
+	upstream := &countingResolver{
+		addrs: []netip.Addr{netip.MustParseAddr("192.0.2.1")},
+		ttl:   50 * time.Millisecond,
+	}
+	resolver := NewCachingResolver(upstream)
+
+	for range 3 {
+		addrs, ttl, err := resolver.LookupHost(context.Background(), "Example.com")
+		if err != nil {
+			t.Fatalf("lookup failed: %v", err)
+		}
+		if len(addrs) != 1 || addrs[0] != upstream.addrs[0] {
+			t.Errorf("expected %v, got %v", upstream.addrs, addrs)
+		}
+		if ttl <= 0 || ttl > upstream.ttl {
+			t.Errorf("expected the remaining TTL, got %v", ttl)
+		}
+	}
+	if upstream.lookups != 1 {
+		t.Errorf("expected 1 lookup while the answer is fresh, got %d", upstream.lookups)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, _, err := resolver.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if upstream.lookups != 2 {
+		t.Errorf("expected the expired answer to be looked up again, got %d lookups", upstream.lookups)
+	}
+
+	failing := &countingResolver{err: errors.New("SERVFAIL")}
+	resolver = NewCachingResolver(failing)
+	for range 2 {
+		if _, _, err := resolver.LookupHost(context.Background(), "example.com"); err == nil {
+			t.Fatalf("expected the lookup to fail")
+		}
+	}
+	if failing.lookups != 2 {
+		t.Errorf("expected failures not to be cached, got %d lookups", failing.lookups)
+	}
+}
+
+func TestHostsOverride(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	recorder := &eventRecorder{}
+	e := NewEngine(
+		WithHosts(map[string]string{"www.example.com": "127.0.0.1"}),
+		WithResolver(&countingResolver{err: errors.New("resolver shouldn't be asked")}),
+		WithObserver(recorder),
+	)
+	url, err := Parse("http://www.example.com:" + port + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	response, err := e.Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if string(response.Body) != "www.example.com:"+port {
+		t.Errorf("expected the request to keep its Host, got %q", response.Body)
+	}
+
+	var dns []DNSEvent
+	for _, event := range recorder.events {
+		if ev, ok := event.(DNSEvent); ok {
+			dns = append(dns, ev)
+		}
+	}
+	if len(dns) != 1 || dns[0].Host != "www.example.com" || len(dns[0].Addrs) != 1 || dns[0].Addrs[0] != "127.0.0.1" {
+		t.Errorf("expected one DNS event resolving to 127.0.0.1, got %+v", dns)
+	}
+}
+
+func TestResolverErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{"Resolver failure", WithResolver(&countingResolver{err: errors.New("SERVFAIL")})},
+		{"No addresses", WithResolver(&countingResolver{ttl: time.Minute})},
+		{"Invalid override", WithHosts(map[string]string{"example.com": "not-an-ip"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, err := Parse("http://example.com/")
+			if err != nil {
+				t.Fatalf("failed to parse URL: %v", err)
+			}
+			_, err = NewEngine(tt.opt, WithTransport(failingTransport{})).Request(url, nil)
+			if !errors.Is(err, ErrDNS) {
+				t.Errorf("expected %v, got %v", ErrDNS, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how the engine retries requests that failed in ways
+// that are likely to go away. Only idempotent requests are retried, which
+// is all of them since the engine only sends GET.
+type RetryPolicy struct {
+	// MaxAttempts caps the attempts per request, counting the first one.
+	// One or less disables retries.
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry. Later retries
+	// double it, up to MaxDelay, and each delay is jittered.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxRetryAfter is the longest a Retry-After header may make the
+	// engine wait. Responses asking for longer are returned as they are.
+	MaxRetryAfter time.Duration
+}
+
+// DEFAULT_RETRY_POLICY is the retry policy engines start with.
+var DEFAULT_RETRY_POLICY = RetryPolicy{
+	MaxAttempts:   3,
+	BaseDelay:     100 * time.Millisecond,
+	MaxDelay:      2 * time.Second,
+	MaxRetryAfter: 10 * time.Second,
+}
+
+// WithRetryPolicy replaces the engine's retry policy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(e *Engine) {
+		e.retry = policy
+	}
+}
+
+// roundTripWithRetries sends a request for url, retrying it as the retry
+// policy allows. A request that fails on a pooled connection the server
+// has since closed, or that an HTTP/2 server turned away unprocessed, is
+// retried straight away on a new one. Once part of a
+// body has gone to sink it can't be taken back, so the request is no
+// longer retried; resuming it is up to the caller.
+func (e *Engine) roundTripWithRetries(url *URL, proxyURL *neturl.URL, headers map[string]string, sink BodySink) (*Response, error) {
+	streamed := false
+	if sink != nil {
+		next := sink
+		sink = func(r *Response) (io.Writer, error) {
+			w, err := next(r)
+			streamed = streamed || w != nil
+			return w, err
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		r, reused, err := e.roundTrip(url, proxyURL, headers, sink)
+		if attempt >= e.retry.MaxAttempts || streamed {
+			return r, err
+		}
+
+		var delay time.Duration
+		switch {
+		case err != nil && (reused && isStaleConnError(err) || errors.Is(err, errHTTP2ConnClosed)):
+		case err != nil && isResetError(err):
+			delay = e.retry.backoff(attempt)
+		case err == nil && (r.StatusCode == 429 || r.StatusCode == 503):
+			wait, ok := retryAfter(r.Headers, time.Now())
+			if !ok || wait > e.retry.MaxRetryAfter {
+				return r, nil
+			}
+			delay = wait
+		default:
+			return r, err
+		}
+
+		event := RetryEvent{URL: url.String(), Attempt: attempt + 1, Delay: delay, Err: err}
+		if r != nil {
+			event.StatusCode = r.StatusCode
+		}
+		e.emit(event)
+		time.Sleep(delay)
+	}
+}
+
+// backoff returns the jittered delay before retry number attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay > p.MaxDelay || delay <= 0 {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// Spread retries between half and all of the delay so clients that
+	// failed together don't retry together.
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// isStaleConnError reports whether err is what a request on a pooled
+// connection the server already closed runs into.
+func isStaleConnError(err error) bool {
+	return isResetError(err) || errors.Is(err, errHTTP2ConnClosed)
+}
+
+// isResetError reports whether the connection was reset or closed before
+// the response arrived.
+func isResetError(err error) bool {
+	for _, reset := range resetErrors {
+		if errors.Is(err, reset) {
+			return true
+		}
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryAfter reads how long a Retry-After header asks to wait, given as
+// seconds or an HTTP date.
+func retryAfter(headers map[string]string, now time.Time) (time.Duration, bool) {
+	value, ok := getHeader(headers, "Retry-After")
+	if !ok {
+		return 0, false
+	}
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := time.Parse(time.RFC1123, value)
+	if err != nil {
+		return 0, false
+	}
+	return max(date.Sub(now), 0), true
+}
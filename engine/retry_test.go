@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryStalePooledConnection(t *testing.T) {
+	// The server closes every connection after answering, without saying
+	// so, like one whose keep-alive timeout has passed.
+	addr, conns := serveRawHTTP(t, func() (string, bool) {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", true
+	})
+	recorder := &eventRecorder{}
+	e := NewEngine(WithObserver(recorder))
+
+	url, err := Parse("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	for i := range 2 {
+		response, err := e.Request(url, map[string]string{"Connection": "keep-alive"})
+		if err != nil {
+			t.Fatalf("request %d failed: %v", i+1, err)
+		}
+		if string(response.Body) != "ok" {
+			t.Errorf("expected %q, got %q", "ok", response.Body)
+		}
+		// Give the server time to close the connection.
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := conns.Load(); n != 2 {
+		t.Errorf("expected a new connection for the retry, got %d connections", n)
+	}
+
+	var retries []RetryEvent
+	for _, event := range recorder.events {
+		if retry, ok := event.(RetryEvent); ok {
+			retries = append(retries, retry)
+		}
+	}
+	if len(retries) != 1 || retries[0].Delay != 0 {
+		t.Errorf("expected one immediate retry, got %+v", retries)
+	}
+}
+
+func TestRetryResponses(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []func(w http.ResponseWriter)
+		status    int
+		attempts  int32
+	}{
+		{
+			name: "503 with Retry-After",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(http.StatusServiceUnavailable)
+				},
+				func(w http.ResponseWriter) { fmt.Fprint(w, "ok") },
+			},
+			status:   http.StatusOK,
+			attempts: 2,
+		},
+		{
+			name: "429 with an HTTP date",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("Retry-After", "Wed, 21 Oct 2015 07:28:00 GMT")
+					w.WriteHeader(http.StatusTooManyRequests)
+				},
+				func(w http.ResponseWriter) { fmt.Fprint(w, "ok") },
+			},
+			status:   http.StatusOK,
+			attempts: 2,
+		},
+		{
+			name: "Retry-After beyond the limit",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("Retry-After", "3600")
+					w.WriteHeader(http.StatusServiceUnavailable)
+				},
+			},
+			status:   http.StatusServiceUnavailable,
+			attempts: 1,
+		},
+		{
+			name: "503 without Retry-After",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) },
+			},
+			status:   http.StatusServiceUnavailable,
+			attempts: 1,
+		},
+		{
+			name: "Attempts are capped",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(http.StatusServiceUnavailable)
+				},
+			},
+			status:   http.StatusServiceUnavailable,
+			attempts: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(attempts.Add(1))
+				tt.responses[min(n, len(tt.responses))-1](w)
+			}))
+			defer server.Close()
+
+			url, err := Parse(server.URL + "/")
+			if err != nil {
+				t.Fatalf("failed to parse URL: %v", err)
+			}
+			response, err := NewEngine().Request(url, nil)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if response.StatusCode != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, response.StatusCode)
+			}
+			if n := attempts.Load(); n != tt.attempts {
+				t.Errorf("expected %d attempts, got %d", tt.attempts, n)
+			}
+		})
+	}
+}
+
+func TestRetryConnectionReset(t *testing.T) {
+	var attempts atomic.Int32
+	addr, _ := serveRawHTTP(t, func() (string, bool) {
+		if attempts.Add(1) < 3 {
+			return "", true
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", true
+	})
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	url, err := Parse("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	response, err := NewEngine(WithRetryPolicy(policy)).Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if string(response.Body) != "ok" || attempts.Load() != 3 {
+		t.Errorf("expected success on the third attempt, got %q after %d", response.Body, attempts.Load())
+	}
+
+	attempts.Store(-10)
+	policy.MaxAttempts = 1
+	_, err = NewEngine(WithRetryPolicy(policy)).Request(url, nil)
+	if !errors.Is(err, ErrProtocol) || attempts.Load() != -9 {
+		t.Errorf("expected one failed attempt without retries, got %v after %d", err, attempts.Load()+10)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{10, 500 * time.Millisecond, time.Second},
+		{100, 500 * time.Millisecond, time.Second},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			if delay := policy.backoff(tt.attempt); delay < tt.min || delay > tt.max {
+				t.Errorf("for attempt %d, expected a delay in [%v, %v], got %v", tt.attempt, tt.min, tt.max, delay)
+			}
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{"Wed, 21 Oct 2015 07:28:30 GMT", 30 * time.Second, true},
+		{"Wed, 21 Oct 2015 07:00:00 GMT", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := retryAfter(map[string]string{"Retry-After": tt.value}, now)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("for %q, expected %v %v, got %v %v", tt.value, tt.expected, tt.ok, got, ok)
+		}
+	}
+}
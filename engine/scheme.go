@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SchemeHandler loads the URLs of one scheme. The engine takes care of
+// what is common to every scheme around it, such as the cache, events and
+// saving downloads.
+type SchemeHandler interface {
+	Load(e *Engine, url *URL, headers map[string]string) (*Response, error)
+}
+
+// SchemeHandlerFunc lets an ordinary function be used as a SchemeHandler.
+type SchemeHandlerFunc func(e *Engine, url *URL, headers map[string]string) (*Response, error)
+
+func (f SchemeHandlerFunc) Load(e *Engine, url *URL, headers map[string]string) (*Response, error) {
+	return f(e, url, headers)
+}
+
+// StreamingSchemeHandler is a SchemeHandler that can write a body to a
+// sink as it arrives instead of handing over all of it at the end, so
+// that large downloads don't have to fit in memory.
+type StreamingSchemeHandler interface {
+	SchemeHandler
+	// LoadStream loads url like Load, except that once the status and
+	// headers of the response are known it passes them to sink. If sink
+	// returns a writer the body goes there, and is left out of the
+	// returned Response; otherwise it goes into Response.Body as usual.
+	LoadStream(e *Engine, url *URL, headers map[string]string, sink BodySink) (*Response, error)
+}
+
+var (
+	schemesMu sync.RWMutex
+	schemes   = make(map[string]SchemeHandler)
+)
+
+func init() {
+	RegisterScheme("http", httpScheme{})
+	RegisterScheme("https", httpScheme{})
+	RegisterScheme("file", SchemeHandlerFunc(loadFile))
+	RegisterScheme("data", SchemeHandlerFunc(loadData))
+	RegisterScheme("about", SchemeHandlerFunc(loadAbout))
+}
+
+// RegisterScheme makes Parse accept URLs with the given scheme and the
+// engine load them through handler, replacing any handler the scheme had.
+// URLs of the scheme can be hierarchical, like "scheme://host/path", or
+// opaque, like "scheme:anything". Handlers that also implement
+// StreamingSchemeHandler have their bodies streamed to downloads; the
+// bodies of others are held in memory first.
+func RegisterScheme(scheme string, handler SchemeHandler) {
+	schemesMu.Lock()
+	defer schemesMu.Unlock()
+	schemes[strings.ToLower(scheme)] = handler
+}
+
+func lookupScheme(scheme string) (SchemeHandler, bool) {
+	schemesMu.RLock()
+	defer schemesMu.RUnlock()
+	handler, ok := schemes[scheme]
+	return handler, ok
+}
+
+// httpScheme fetches http and https URLs over the network.
+type httpScheme struct{}
+
+func (httpScheme) Load(e *Engine, url *URL, headers map[string]string) (*Response, error) {
+	return e.fetch(url, headers, nil)
+}
+
+func (httpScheme) LoadStream(e *Engine, url *URL, headers map[string]string, sink BodySink) (*Response, error) {
+	return e.fetch(url, headers, sink)
+}
+
+// loadFile reads file URLs from the local file system.
+func loadFile(e *Engine, url *URL, headers map[string]string) (*Response, error) {
+	bytes, err := os.ReadFile(url.path)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		Headers: make(map[string]string),
+		Body:    bytes,
+	}, nil
+}
+
+// loadData decodes the content of data URLs, as plain or base64 text.
+func loadData(e *Engine, url *URL, headers map[string]string) (*Response, error) {
+	commaIndex := strings.Index(url.path, ",")
+	if commaIndex == -1 {
+		return nil, requestError(url, ErrDecode, fmt.Errorf("invalid data URL"))
+	}
+	meta := url.path[:commaIndex]
+	data := url.path[commaIndex+1:]
+	isBase64 := strings.Contains(meta, ";base64")
+	if isBase64 {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, requestError(url, ErrDecode, err)
+		}
+		return &Response{
+			Headers: make(map[string]string),
+			Body:    decoded,
+		}, nil
+	}
+	unescaped, err := urlUnescape(data)
+	if err != nil {
+		return nil, requestError(url, ErrDecode, err)
+	}
+	return &Response{
+		Headers: make(map[string]string),
+		Body:    []byte(unescaped),
+	}, nil
+}
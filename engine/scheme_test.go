@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegisterScheme(t *testing.T) {
+	RegisterScheme("echo", SchemeHandlerFunc(func(e *Engine, url *URL, headers map[string]string) (*Response, error) {
+		return &Response{
+			StatusCode: 200,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       []byte(url.Host() + "|" + url.Path() + "|" + headers["X-Test"]),
+		}, nil
+	}))
+
+	testCases := []struct {
+		name     string
+		url      string
+		expected string
+		str      string
+	}{
+		{"Hierarchical", "echo://host/some/path", "host|/some/path|yes", "echo://host/some/path"},
+		{"Opaque", "echo:hello world", "|hello world|yes", "echo:hello world"},
+		{"Upper case scheme", "ECHO:hi", "|hi|yes", "echo:hi"},
+	}
+
+	e := NewEngine()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			url, err := Parse(tc.url)
+			if err != nil {
+				t.Fatalf("failed to parse URL: %v", err)
+			}
+			if url.Scheme() != "echo" {
+				t.Errorf("expected scheme %q, got %q", "echo", url.Scheme())
+			}
+			if url.String() != tc.str {
+				t.Errorf("expected %q, got %q", tc.str, url.String())
+			}
+			r, err := e.Request(url, map[string]string{"X-Test": "yes"})
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if string(r.Body) != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, string(r.Body))
+			}
+			if r.URL != tc.str {
+				t.Errorf("expected URL %q, got %q", tc.str, r.URL)
+			}
+		})
+	}
+}
+
+func TestParseUnsupportedScheme(t *testing.T) {
+	testCases := []struct {
+		url      string
+		expected string
+	}{
+		{"gopher://example.com/", "unsupported scheme: gopher"},
+		{"nothing:at-all", "unsupported scheme: nothing"},
+		{"no-colon", "invalid URL format"},
+		{"http:example.com", "invalid URL format"},
+	}
+
+	for _, tc := range testCases {
+		_, err := Parse(tc.url)
+		if err == nil {
+			t.Fatalf("expected error for %q", tc.url)
+		}
+		if !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("expected %q, got %q", tc.expected, err.Error())
+		}
+	}
+}
+
+func TestDataURLString(t *testing.T) {
+	url, err := Parse("data:,hello")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	if url.String() != "data:,hello" {
+		t.Errorf("expected %q, got %q", "data:,hello", url.String())
+	}
+}
+
+// chunkScheme streams its body in chunks to show it never holds all of
+// it at once.
+type chunkScheme struct{}
+
+func (s chunkScheme) Load(e *Engine, url *URL, headers map[string]string) (*Response, error) {
+	return s.LoadStream(e, url, headers, func(r *Response) (io.Writer, error) { return nil, nil })
+}
+
+func (chunkScheme) LoadStream(e *Engine, url *URL, headers map[string]string, sink BodySink) (*Response, error) {
+	r := &Response{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/octet-stream"},
+	}
+	w, err := sink(r)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	if w == nil {
+		w = &body
+	}
+	for range 4 {
+		if _, err := io.WriteString(w, "chunk"); err != nil {
+			return nil, err
+		}
+	}
+	r.Body = body.Bytes()
+	return r, nil
+}
+
+func TestStreamingSchemeHandler(t *testing.T) {
+	RegisterScheme("chunks", chunkScheme{})
+	url, err := Parse("chunks://host/file.bin")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+
+	dir := t.TempDir()
+	r, err := NewEngine(WithDownloadDir(dir)).Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if r.Download == nil || r.Body != nil {
+		t.Fatalf("expected the body to be streamed to a download, got %+v", r)
+	}
+	data, err := os.ReadFile(r.Download.Path)
+	if err != nil {
+		t.Fatalf("failed to read download: %v", err)
+	}
+	if string(data) != strings.Repeat("chunk", 4) {
+		t.Errorf("expected %q, got %q", strings.Repeat("chunk", 4), data)
+	}
+	if filepath.Base(r.Download.Path) != "file.bin" {
+		t.Errorf("expected %q, got %q", "file.bin", filepath.Base(r.Download.Path))
+	}
+
+	r, err = NewEngine().Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if string(r.Body) != strings.Repeat("chunk", 4) {
+		t.Errorf("expected the body in memory without a download directory, got %q", r.Body)
+	}
+}
+
+func TestSchemesSkipCache(t *testing.T) {
+	recorder := &eventRecorder{}
+	e := NewEngine(WithObserver(recorder))
+	for _, rawURL := range []string{"data:,hello", "about:blank"} {
+		url, err := Parse(rawURL)
+		if err != nil {
+			t.Fatalf("failed to parse URL: %v", err)
+		}
+		if _, err := e.Request(url, nil); err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+	}
+	if stats := e.CacheStats(); stats.Misses != 0 {
+		t.Errorf("expected no cache lookups, got %d misses", stats.Misses)
+	}
+	for _, event := range recorder.events {
+		if _, ok := event.(CacheLookupEvent); ok {
+			t.Errorf("expected no CacheLookupEvent, got %+v", event)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	neturl "net/url"
+	"strconv"
+)
+
+// SOCKS5 protocol constants from RFC 1928 and RFC 1929.
+const (
+	socksVersion5 = 0x05
+
+	socksAuthNone         = 0x00
+	socksAuthPassword     = 0x02
+	socksAuthNoAcceptable = 0xff
+
+	socksCmdConnect = 0x01
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+)
+
+var socksReplies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// socksConnect asks the SOCKS5 proxy on conn to connect to hostWithPort.
+// With a socks5h proxy URL the host name is sent to the proxy to resolve,
+// otherwise the engine resolves it first.
+func (e *Engine) socksConnect(conn io.ReadWriter, hostWithPort string, proxyURL *neturl.URL, trace *requestTrace) error {
+	host, portStr, err := net.SplitHostPort(hostWithPort)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 0xffff {
+		return fmt.Errorf("invalid port: %s", portStr)
+	}
+
+	if err := socksAuthenticate(conn, proxyURL); err != nil {
+		return err
+	}
+
+	req := []byte{socksVersion5, socksCmdConnect, 0x00}
+	ip := net.ParseIP(host)
+	if ip == nil && proxyURL.Scheme == "socks5" {
+		addrs, err := e.resolve(context.Background(), host, trace)
+		if err != nil {
+			return err
+		}
+		ip = addrs[0].AsSlice()
+	}
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long for SOCKS5: %s", host)
+		}
+		req = append(req, socksAddrDomain, byte(len(host)))
+		req = append(req, host...)
+	case ip.To4() != nil:
+		req = append(req, socksAddrIPv4)
+		req = append(req, ip.To4()...)
+	default:
+		req = append(req, socksAddrIPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("reading SOCKS5 reply: %v", err)
+	}
+	if reply[0] != socksVersion5 {
+		return fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+	if reply[1] != 0x00 {
+		if reason, ok := socksReplies[reply[1]]; ok {
+			return fmt.Errorf("SOCKS5 connect to %s failed: %s", hostWithPort, reason)
+		}
+		return fmt.Errorf("SOCKS5 connect to %s failed with code %d", hostWithPort, reply[1])
+	}
+
+	// Skip the bound address the proxy reports; we don't need it.
+	var skip int
+	switch reply[3] {
+	case socksAddrIPv4:
+		skip = net.IPv4len
+	case socksAddrIPv6:
+		skip = net.IPv6len
+	case socksAddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0])
+	default:
+		return fmt.Errorf("unknown SOCKS5 address type %d", reply[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip+2)); err != nil {
+		return fmt.Errorf("reading SOCKS5 reply: %v", err)
+	}
+	return nil
+}
+
+// socksAuthenticate negotiates an authentication method, offering
+// username/password only when the proxy URL carries credentials.
+func socksAuthenticate(conn io.ReadWriter, proxyURL *neturl.URL) error {
+	methods := []byte{socksAuthNone}
+	if proxyURL.User != nil {
+		methods = append(methods, socksAuthPassword)
+	}
+	greeting := append([]byte{socksVersion5, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	choice := make([]byte, 2)
+	if _, err := io.ReadFull(conn, choice); err != nil {
+		return fmt.Errorf("reading SOCKS5 greeting: %v", err)
+	}
+	if choice[0] != socksVersion5 {
+		return fmt.Errorf("unexpected SOCKS version %d", choice[0])
+	}
+
+	switch choice[1] {
+	case socksAuthNone:
+		return nil
+	case socksAuthPassword:
+		if proxyURL.User == nil {
+			return errors.New("SOCKS5 proxy requested credentials we didn't offer")
+		}
+		username := proxyURL.User.Username()
+		password, _ := proxyURL.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return errors.New("SOCKS5 credentials too long")
+		}
+		req := []byte{0x01, byte(len(username))}
+		req = append(req, username...)
+		req = append(req, byte(len(password)))
+		req = append(req, password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		status := make([]byte, 2)
+		if _, err := io.ReadFull(conn, status); err != nil {
+			return fmt.Errorf("reading SOCKS5 auth reply: %v", err)
+		}
+		if status[1] != 0x00 {
+			return errors.New("SOCKS5 authentication failed")
+		}
+		return nil
+	case socksAuthNoAcceptable:
+		return errors.New("SOCKS5 proxy accepted none of our authentication methods")
+	default:
+		return fmt.Errorf("SOCKS5 proxy chose unsupported authentication method %d", choice[1])
+	}
+}
@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// serveSOCKS5 runs a minimal SOCKS5 proxy requiring user/secret and sends
+// the address each client asked for on requested.
+func serveSOCKS5(t *testing.T, requested chan<- string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer client.Close()
+				buf := make([]byte, 512)
+
+				io.ReadFull(client, buf[:2])
+				io.ReadFull(client, buf[:buf[1]])
+				client.Write([]byte{socksVersion5, socksAuthPassword})
+
+				io.ReadFull(client, buf[:2])
+				username := make([]byte, buf[1])
+				io.ReadFull(client, username)
+				io.ReadFull(client, buf[:1])
+				password := make([]byte, buf[0])
+				io.ReadFull(client, password)
+				if string(username) != "user" || string(password) != "secret" {
+					client.Write([]byte{0x01, 0x01})
+					return
+				}
+				client.Write([]byte{0x01, 0x00})
+
+				io.ReadFull(client, buf[:4])
+				var host string
+				switch buf[3] {
+				case socksAddrDomain:
+					io.ReadFull(client, buf[:1])
+					name := make([]byte, buf[0])
+					io.ReadFull(client, name)
+					host = string(name)
+				case socksAddrIPv4:
+					io.ReadFull(client, buf[:4])
+					host = net.IP(buf[:4]).String()
+				}
+				io.ReadFull(client, buf[:2])
+				port := binary.BigEndian.Uint16(buf[:2])
+				address := net.JoinHostPort(host, strconv.Itoa(int(port)))
+				requested <- address
+
+				upstream, err := net.Dial("tcp", address)
+				if err != nil {
+					client.Write([]byte{socksVersion5, 0x05, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer upstream.Close()
+				client.Write([]byte{socksVersion5, 0x00, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+				go io.Copy(upstream, client)
+				io.Copy(client, upstream)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello via SOCKS")
+	}))
+	defer target.Close()
+	_, port, _ := net.SplitHostPort(target.Listener.Addr().String())
+
+	requested := make(chan string, 1)
+	proxy := serveSOCKS5(t, requested)
+	defer proxy.Close()
+
+	e := NewEngine(WithProxy("socks5h://user:secret@" + proxy.Addr().String()))
+
+	url, err := Parse("http://localhost:" + port + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	response, err := e.Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if string(response.Body) != "Hello via SOCKS" {
+		t.Errorf("expected response via SOCKS proxy, got %q", string(response.Body))
+	}
+	// socks5h leaves name resolution to the proxy.
+	if address := <-requested; address != "localhost:"+port {
+		t.Errorf("expected proxy to be asked for localhost:%s, got %q", port, address)
+	}
+}
+
+func TestSOCKS5ProxyBadCredentials(t *testing.T) {
+	proxy := serveSOCKS5(t, make(chan string, 1))
+	defer proxy.Close()
+
+	e := NewEngine(WithProxy("socks5h://user:wrong@" + proxy.Addr().String()))
+
+	url, err := Parse("http://localhost:1/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	if _, err := e.Request(url, nil); err == nil {
+		t.Errorf("expected request with bad SOCKS credentials to fail")
+	}
+}
@@ -0,0 +1,236 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrOffline is what connecting fails with while the engine emulates an
+// offline network.
+var ErrOffline = errors.New("network is offline")
+
+// NetworkConditions describe a network link for the engine to emulate.
+// Zero values leave that aspect of the real network alone.
+type NetworkConditions struct {
+	// Offline makes every connection attempt and host name lookup fail.
+	Offline bool
+	// Latency is added to every round trip: once for each lookup through
+	// the engine's resolver, once when connecting and once for the first
+	// read after each write.
+	Latency time.Duration
+	// DownloadRate and UploadRate cap the throughput of the link in bytes
+	// per second, shared by all of its connections.
+	DownloadRate int64
+	UploadRate   int64
+	// ErrorRate is the chance, from 0 to 1, that a read from a connection
+	// fails as if the connection had been reset.
+	ErrorRate float64
+}
+
+// networkPresets roughly follow the throttling presets of browser
+// developer tools, with rates converted from kbit/s to bytes per second.
+var networkPresets = map[string]NetworkConditions{
+	"offline": {Offline: true},
+	"gprs":    {Latency: 500 * time.Millisecond, DownloadRate: 50_000 / 8, UploadRate: 20_000 / 8},
+	"2g":      {Latency: 300 * time.Millisecond, DownloadRate: 250_000 / 8, UploadRate: 50_000 / 8},
+	"slow-3g": {Latency: 2000 * time.Millisecond, DownloadRate: 400_000 / 8, UploadRate: 400_000 / 8},
+	"3g":      {Latency: 562 * time.Millisecond, DownloadRate: 1_440_000 / 8, UploadRate: 675_000 / 8},
+	"4g":      {Latency: 170 * time.Millisecond, DownloadRate: 9_000_000 / 8, UploadRate: 9_000_000 / 8},
+	"wifi":    {Latency: 2 * time.Millisecond, DownloadRate: 30_000_000 / 8, UploadRate: 15_000_000 / 8},
+}
+
+// NetworkPreset returns the conditions of a named network such as "3G" or
+// "offline". Names are case-insensitive.
+func NetworkPreset(name string) (NetworkConditions, bool) {
+	conditions, ok := networkPresets[strings.ToLower(name)]
+	return conditions, ok
+}
+
+// NetworkPresetNames lists the names NetworkPreset knows, sorted.
+func NetworkPresetNames() []string {
+	names := make([]string, 0, len(networkPresets))
+	for name := range networkPresets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// WithNetworkConditions makes the engine behave as if it were on a network
+// with the given conditions, by throttling its transport and resolver. It
+// applies to the transport and resolver the engine ends up with, whatever
+// order the options come in.
+func WithNetworkConditions(conditions NetworkConditions) Option {
+	return func(e *Engine) {
+		e.conditions = &conditions
+	}
+}
+
+// Throttle wraps transport so that its connections behave as if they ran
+// over a link with the given conditions.
+func Throttle(transport Transport, conditions NetworkConditions) Transport {
+	return &throttledTransport{
+		transport:  transport,
+		conditions: conditions,
+		download:   &rateLimiter{rate: conditions.DownloadRate},
+		upload:     &rateLimiter{rate: conditions.UploadRate},
+	}
+}
+
+type throttledTransport struct {
+	transport  Transport
+	conditions NetworkConditions
+	download   *rateLimiter
+	upload     *rateLimiter
+}
+
+func (t *throttledTransport) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if t.conditions.Offline {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: ErrOffline}
+	}
+	if err := sleepContext(ctx, t.conditions.Latency); err != nil {
+		return nil, err
+	}
+	conn, err := t.transport.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	connCtx, cancel := context.WithCancel(context.Background())
+	return &throttledConn{Conn: conn, t: t, ctx: connCtx, cancel: cancel}, nil
+}
+
+// throttledConn delays and paces the bytes going through a connection.
+type throttledConn struct {
+	net.Conn
+	t *throttledTransport
+	// ctx is canceled when the connection is closed, cutting short any
+	// delay a read or write is waiting out.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	// wrote is set by a write and cleared by the read that answers it.
+	wrote bool
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	wrote := c.wrote
+	c.wrote = false
+	c.mu.Unlock()
+	if wrote {
+		if err := sleepContext(c.ctx, c.t.conditions.Latency); err != nil {
+			return 0, c.closedError("read")
+		}
+	}
+
+	if c.t.conditions.ErrorRate > 0 && rand.Float64() < c.t.conditions.ErrorRate {
+		c.Conn.Close()
+		return 0, &net.OpError{Op: "read", Net: "tcp", Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: errConnReset}
+	}
+	n, err := c.Conn.Read(c.t.download.limit(p))
+	if waitErr := c.t.download.wait(c.ctx, n); waitErr != nil && err == nil {
+		err = c.closedError("read")
+	}
+	return n, err
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := c.t.upload.limit(p)
+		if err := c.t.upload.wait(c.ctx, len(chunk)); err != nil {
+			return written, c.closedError("write")
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	c.mu.Lock()
+	c.wrote = true
+	c.mu.Unlock()
+	return written, nil
+}
+
+func (c *throttledConn) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
+
+// closedError is what an operation cut short by Close fails with.
+func (c *throttledConn) closedError(op string) error {
+	return &net.OpError{Op: op, Net: "tcp", Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: net.ErrClosed}
+}
+
+// throttledResolver adds the latency of a round trip to every lookup.
+type throttledResolver struct {
+	resolver Resolver
+	latency  time.Duration
+}
+
+func (r *throttledResolver) LookupHost(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	if err := sleepContext(ctx, r.latency); err != nil {
+		return nil, 0, err
+	}
+	return r.resolver.LookupHost(ctx, host)
+}
+
+// rateLimiter paces bytes to rate per second by making each transfer
+// wait until the link would have carried it.
+type rateLimiter struct {
+	rate int64
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// limit shortens p to about a tenth of a second's worth of bytes, so that
+// large transfers are paced smoothly instead of in one long wait.
+func (l *rateLimiter) limit(p []byte) []byte {
+	if l.rate <= 0 {
+		return p
+	}
+	return p[:min(len(p), int(max(l.rate/10, 1)))]
+}
+
+// wait blocks until n more bytes fit within the rate, or until ctx is
+// done.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l.rate <= 0 || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+	return sleepContext(ctx, delay)
+}
+
+// sleepContext sleeps for d unless ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	body := strings.Repeat("x", 20_000)
+	tests := []struct {
+		name       string
+		conditions NetworkConditions
+		// minimum is how long the request must take at least.
+		minimum time.Duration
+		err     error
+	}{
+		{
+			name:       "Latency",
+			conditions: NetworkConditions{Latency: 50 * time.Millisecond},
+			// One round trip to connect and one for the request.
+			minimum: 100 * time.Millisecond,
+		},
+		{
+			name:       "Download rate",
+			conditions: NetworkConditions{DownloadRate: 100_000},
+			minimum:    200 * time.Millisecond,
+		},
+		{
+			name:       "Upload rate",
+			conditions: NetworkConditions{UploadRate: 500},
+			// The request headers alone are over 50 bytes.
+			minimum: 100 * time.Millisecond,
+		},
+		{
+			name:       "Offline",
+			conditions: NetworkConditions{Offline: true},
+			err:        ErrOffline,
+		},
+		{
+			name:       "Errors",
+			conditions: NetworkConditions{ErrorRate: 1},
+			err:        errConnReset,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := newPipeTransport(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, body)
+			}))
+			e := NewEngine(
+				WithNetworkConditions(tt.conditions),
+				WithTransport(transport),
+				WithHosts(map[string]string{"example.com": "192.0.2.1"}),
+				WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+			)
+			url, err := Parse("http://example.com/")
+			if err != nil {
+				t.Fatalf("failed to parse URL: %v", err)
+			}
+
+			start := time.Now()
+			response, err := e.Request(url, nil)
+			elapsed := time.Since(start)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if string(response.Body) != body {
+				t.Errorf("expected a %d byte body, got %d bytes", len(body), len(response.Body))
+			}
+			if elapsed < tt.minimum {
+				t.Errorf("expected the request to take at least %v, took %v", tt.minimum, elapsed)
+			}
+		})
+	}
+}
+
+func TestNetworkPreset(t *testing.T) {
+	for _, name := range []string{"3G", "slow-3g", "Offline", "wifi"} {
+		if _, ok := NetworkPreset(name); !ok {
+			t.Errorf("expected a preset for %q", name)
+		}
+	}
+	if _, ok := NetworkPreset("5g-ultra"); ok {
+		t.Errorf("expected no preset for an unknown name")
+	}
+	if conditions, _ := NetworkPreset("offline"); !conditions.Offline {
+		t.Errorf("expected the offline preset to be offline")
+	}
+}
+
+func TestThrottleOfflineSkipsDNS(t *testing.T) {
+	resolver := &countingResolver{err: errors.New("resolver shouldn't be asked")}
+	e := NewEngine(WithNetworkConditions(NetworkConditions{Offline: true}), WithResolver(resolver))
+	url, err := Parse("http://unresolvable.invalid/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	_, err = e.Request(url, nil)
+	if !errors.Is(err, ErrOffline) || errors.Is(err, ErrDNS) {
+		t.Errorf("expected %v and not %v, got %v", ErrOffline, ErrDNS, err)
+	}
+	if resolver.lookups != 0 {
+		t.Errorf("expected no lookups while offline, got %d", resolver.lookups)
+	}
+}
+
+func TestThrottleDNSLatency(t *testing.T) {
+	const latency = 50 * time.Millisecond
+	recorder := &eventRecorder{}
+	e := NewEngine(
+		WithNetworkConditions(NetworkConditions{Latency: latency}),
+		WithTransport(failingTransport{}),
+		WithResolver(&countingResolver{addrs: []netip.Addr{netip.MustParseAddr("192.0.2.1")}, ttl: time.Minute}),
+		WithObserver(recorder),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+	)
+	url, err := Parse("http://example.com/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	e.Request(url, nil)
+
+	for _, event := range recorder.events {
+		if ev, ok := event.(DNSEvent); ok {
+			if ev.Duration < latency {
+				t.Errorf("expected the lookup to take at least %v, took %v", latency, ev.Duration)
+			}
+			return
+		}
+	}
+	t.Errorf("expected a DNS event")
+}
+
+func TestThrottleCloseInterruptsLatency(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	// Dialing would wait out the latency too, so the connection is set up
+	// by hand.
+	transport := Throttle(nil, NetworkConditions{Latency: time.Hour}).(*throttledTransport)
+	conn := &throttledConn{Conn: client, t: transport}
+	conn.ctx, conn.cancel = context.WithCancel(context.Background())
+
+	go func() {
+		io.ReadAll(server)
+	}()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("expected %v, got %v", net.ErrClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Close to interrupt the read")
+	}
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+)
+
+// TLSOptions describes how the engine sets up https connections.
+type TLSOptions struct {
+	// RootCAFiles are PEM files with the certificate authorities to trust.
+	// The system roots are used when it is empty.
+	RootCAFiles []string
+	// ClientCertFile and ClientKeyFile hold a PEM certificate and key that
+	// are presented to servers asking for client authentication.
+	ClientCertFile string
+	ClientKeyFile  string
+	// MinVersion and MaxVersion bound the negotiated TLS version, e.g.
+	// tls.VersionTLS12. Zero leaves Go's defaults in place.
+	MinVersion uint16
+	MaxVersion uint16
+	// ServerName overrides the name sent in SNI and checked against the
+	// server certificate, which otherwise is the URL's host.
+	ServerName string
+	// NextProtos lists the ALPN protocols offered during the handshake.
+	NextProtos []string
+	// InsecureSkipVerify accepts any server certificate. Only use it for
+	// local testing.
+	InsecureSkipVerify bool
+}
+
+// TLSInfo records what was negotiated on an https connection.
+type TLSInfo struct {
+	Version            uint16
+	CipherSuite        uint16
+	NegotiatedProtocol string
+	ServerName         string
+	// PeerCertificates is the chain the server presented, leaf first.
+	PeerCertificates []*x509.Certificate
+	// Verified is set when the chain was verified against trusted roots,
+	// which it isn't with InsecureSkipVerify.
+	Verified bool
+}
+
+// VersionName returns the human readable TLS version, e.g. "TLS 1.3".
+func (t *TLSInfo) VersionName() string {
+	return tls.VersionName(t.Version)
+}
+
+// CipherSuiteName returns the standard name of the negotiated cipher suite.
+func (t *TLSInfo) CipherSuiteName() string {
+	return tls.CipherSuiteName(t.CipherSuite)
+}
+
+// LoadTLSConfig builds a tls.Config from opts, reading the CA bundles and
+// client certificate it refers to.
+func LoadTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         opts.MinVersion,
+		MaxVersion:         opts.MaxVersion,
+		ServerName:         opts.ServerName,
+		NextProtos:         opts.NextProtos,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if len(opts.RootCAFiles) > 0 {
+		pool := x509.NewCertPool()
+		for _, file := range opts.RootCAFiles {
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", file)
+			}
+		}
+		config.RootCAs = pool
+	}
+
+	if opts.ClientCertFile != "" || opts.ClientKeyFile != "" {
+		if opts.ClientCertFile == "" || opts.ClientKeyFile == "" {
+			return nil, fmt.Errorf("client certificate and key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// WithTLSConfig makes the engine use config for https connections.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(e *Engine) {
+		e.tlsConfig = config
+	}
+}
+
+// ParseTLSVersion converts a version such as "1.2" to its tls constant.
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version: %s", version)
+}
+
+// tlsInfo returns what was negotiated on conn, set up with config, or nil
+// when conn isn't TLS.
+func tlsInfo(conn io.ReadWriteCloser, config *tls.Config) *TLSInfo {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tlsConn.ConnectionState()
+	return &TLSInfo{
+		Version:            state.Version,
+		CipherSuite:        state.CipherSuite,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		ServerName:         state.ServerName,
+		PeerCertificates:   state.PeerCertificates,
+		Verified:           !config.InsecureSkipVerify && len(state.VerifiedChains) > 0,
+	}
+}
@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestTLSOptions(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "no client certificate", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, "Hello, %s", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAnyClientCert,
+		NextProtos: []string{"http/1.1"},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", server.Certificate().Raw)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", certDER)
+	writePEM(t, keyFile, "PRIVATE KEY", keyDER)
+
+	config, err := LoadTLSConfig(TLSOptions{
+		RootCAFiles:    []string{caFile},
+		ClientCertFile: certFile,
+		ClientKeyFile:  keyFile,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"http/1.1"},
+	})
+	if err != nil {
+		t.Fatalf("failed to load TLS config: %v", err)
+	}
+
+	url, err := Parse(server.URL + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	response, err := NewEngine(WithTLSConfig(config)).Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if string(response.Body) != "Hello, test-client" {
+		t.Errorf("expected server to see the client certificate, got %q", string(response.Body))
+	}
+	if response.TLS == nil {
+		t.Fatalf("expected TLS details on the response")
+	}
+	if response.TLS.Version < tls.VersionTLS12 {
+		t.Errorf("expected at least TLS 1.2, got %s", response.TLS.VersionName())
+	}
+	if response.TLS.NegotiatedProtocol != "http/1.1" {
+		t.Errorf("expected ALPN to negotiate http/1.1, got %q", response.TLS.NegotiatedProtocol)
+	}
+	if response.TLS.CipherSuiteName() == "" {
+		t.Errorf("expected a cipher suite name")
+	}
+	if len(response.TLS.PeerCertificates) == 0 || !response.TLS.PeerCertificates[0].Equal(server.Certificate()) {
+		t.Errorf("expected the server certificate as the leaf of the peer chain")
+	}
+	if !response.TLS.Verified {
+		t.Errorf("expected the certificate to be verified")
+	}
+	if response.Connection == nil || response.Connection.RemoteAddr != server.Listener.Addr().String() {
+		t.Errorf("expected remote address %s, got %+v", server.Listener.Addr(), response.Connection)
+	}
+	if response.Connection.Reused {
+		t.Errorf("expected a fresh connection")
+	}
+}
+
+func TestTLSVerification(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secure")
+	}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	url, err := Parse(server.URL + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+
+	if _, err := NewEngine().Request(url, nil); err == nil {
+		t.Errorf("expected untrusted certificate to be rejected")
+	}
+
+	insecure, err := LoadTLSConfig(TLSOptions{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("failed to load TLS config: %v", err)
+	}
+	response, err := NewEngine(WithTLSConfig(insecure)).Request(url, nil)
+	if err != nil {
+		t.Fatalf("expected insecure mode to accept the certificate, got %v", err)
+	}
+	if response.TLS == nil || response.TLS.Verified {
+		t.Errorf("expected TLS details of an unverified certificate, got %+v", response.TLS)
+	}
+
+	tls13, err := LoadTLSConfig(TLSOptions{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13})
+	if err != nil {
+		t.Fatalf("failed to load TLS config: %v", err)
+	}
+	if _, err := NewEngine(WithTLSConfig(tls13)).Request(url, nil); err == nil {
+		t.Errorf("expected handshake to fail when the server tops out below MinVersion")
+	}
+}
@@ -0,0 +1,22 @@
+package engine
+
+import (
+	"context"
+	"net"
+)
+
+// Transport opens the connections an Engine talks over, to servers and
+// proxies alike. TLS and HTTP run on top of the connections it returns,
+// so wrapping a Transport sees every byte the engine sends and receives.
+// *net.Dialer is a Transport.
+type Transport interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// WithTransport makes the engine open its connections through transport
+// instead of dialing the network directly.
+func WithTransport(transport Transport) Option {
+	return func(e *Engine) {
+		e.transport = transport
+	}
+}
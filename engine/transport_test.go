@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// pipeTransport serves handler over in-memory connections instead of the
+// network. Every address dials the same handler, so tests can use any
+// host name.
+type pipeTransport struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+	dials  atomic.Int32
+	mu     sync.Mutex
+	addrs  []string
+}
+
+func newPipeTransport(t *testing.T, handler http.Handler) *pipeTransport {
+	t.Helper()
+	p := &pipeTransport{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(p)
+	t.Cleanup(func() { server.Close() })
+	return p
+}
+
+func (p *pipeTransport) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	p.dials.Add(1)
+	p.mu.Lock()
+	p.addrs = append(p.addrs, address)
+	p.mu.Unlock()
+	client, server := net.Pipe()
+	select {
+	case p.conns <- server:
+		return client, nil
+	case <-p.closed:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Accept, Close and Addr make the transport the server's listener.
+func (p *pipeTransport) Accept() (net.Conn, error) {
+	select {
+	case conn := <-p.conns:
+		return conn, nil
+	case <-p.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (p *pipeTransport) Close() error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
+
+func (p *pipeTransport) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func TestTransport(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello from %s", r.Host)
+	})
+	transport := newPipeTransport(t, mux)
+	e := NewEngine(WithTransport(transport))
+
+	url, err := Parse("http://example.com/hello")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	for range 2 {
+		response, err := e.Request(url, map[string]string{"Connection": "keep-alive"})
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		if string(response.Body) != "Hello from example.com" {
+			t.Errorf("expected %q, got %q", "Hello from example.com", response.Body)
+		}
+	}
+	if dials := transport.dials.Load(); dials != 1 {
+		t.Errorf("expected 1 dial, got %d", dials)
+	}
+	if len(transport.addrs) != 1 || transport.addrs[0] != "example.com:80" {
+		t.Errorf("expected a dial to example.com:80, got %v", transport.addrs)
+	}
+}
+
+func TestTransportDialsProxy(t *testing.T) {
+	var target string
+	transport := newPipeTransport(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.RequestURI
+		fmt.Fprint(w, "proxied")
+	}))
+	e := NewEngine(WithTransport(transport), WithProxy("http://proxy.internal:3128"))
+
+	url, err := Parse("http://example.com/page")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	response, err := e.Request(url, nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if string(response.Body) != "proxied" {
+		t.Errorf("expected %q, got %q", "proxied", response.Body)
+	}
+	if target != "http://example.com/page" {
+		t.Errorf("expected the proxy to see the absolute URL, got %q", target)
+	}
+	if len(transport.addrs) != 1 || transport.addrs[0] != "proxy.internal:3128" {
+		t.Errorf("expected a dial to proxy.internal:3128, got %v", transport.addrs)
+	}
+}
+
+type failingTransport struct{}
+
+func (failingTransport) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return nil, &net.OpError{Op: "dial", Net: network, Err: errors.New("offline")}
+}
+
+func TestTransportErrors(t *testing.T) {
+	e := NewEngine(WithTransport(failingTransport{}))
+	url, err := Parse("http://example.com/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	_, err = e.Request(url, nil)
+	if !errors.Is(err, ErrConnect) {
+		t.Errorf("expected %v, got %v", ErrConnect, err)
+	}
+}
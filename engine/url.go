@@ -2,7 +2,7 @@ package engine
 
 import (
 	"fmt"
-	"slices"
+	"net"
 	"strings"
 )
 
@@ -12,8 +12,20 @@ type URL struct {
 	path       string
 	port       string
 	ViewSource bool
+	// opaque URLs, like "data:,hello", have no "//" after the scheme and
+	// keep everything after the colon in path.
+	opaque bool
+
+	// username and password come from the URL's userinfo, or from a
+	// credentials callback when retrying after a 401.
+	username string
+	password string
 
 	redirectCount int
+	authAttempts  int
+	// auth holds the credentials the engine sent with this request, from
+	// a retry after a 401 or from a protection space it remembers.
+	auth *authGrant
 }
 
 func Parse(url string) (*URL, error) {
@@ -25,22 +37,25 @@ func Parse(url string) (*URL, error) {
 		parsed.ViewSource = true
 		return parsed, nil
 	}
-	parts := strings.Split(url, "://")
-	if strings.HasPrefix(url, "data:") {
-		return &URL{
-			scheme: "data",
-			host:   "",
-			path:   url[5:],
-			port:   "",
-		}, nil
-	}
-	if len(parts) != 2 {
+	scheme, rest, ok := strings.Cut(url, ":")
+	if !ok || scheme == "" {
 		return nil, fmt.Errorf("invalid URL format")
 	}
-	scheme := parts[0]
-	if !slices.Contains(allowedSchemes, scheme) {
+	scheme = strings.ToLower(scheme)
+	if _, ok := lookupScheme(scheme); !ok {
 		return nil, fmt.Errorf("unsupported scheme: %s", scheme)
 	}
+	if !strings.HasPrefix(rest, "//") {
+		// http and https always name a host.
+		if scheme == "http" || scheme == "https" {
+			return nil, fmt.Errorf("invalid URL format")
+		}
+		return &URL{scheme: scheme, path: rest, opaque: true}, nil
+	}
+	parts := strings.Split(url, "://")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid URL format")
+	}
 
 	port := ""
 	if scheme == "http" {
@@ -57,6 +72,20 @@ func Parse(url string) (*URL, error) {
 	parts = strings.SplitN(url, "/", 2)
 	host := parts[0]
 
+	var username, password string
+	if at := strings.LastIndex(host, "@"); at != -1 {
+		userinfo := host[:at]
+		host = host[at+1:]
+		rawUser, rawPassword, _ := strings.Cut(userinfo, ":")
+		var err error
+		if username, err = urlUnescape(rawUser); err != nil {
+			return nil, fmt.Errorf("invalid username: %v", err)
+		}
+		if password, err = urlUnescape(rawPassword); err != nil {
+			return nil, fmt.Errorf("invalid password: %v", err)
+		}
+	}
+
 	if len(parts) > 1 {
 		url = parts[1]
 	} else {
@@ -64,13 +93,59 @@ func Parse(url string) (*URL, error) {
 	}
 
 	return &URL{
-		scheme: scheme,
-		host:   host,
-		path:   "/" + url,
-		port:   port,
+		scheme:   scheme,
+		host:     host,
+		path:     "/" + url,
+		port:     port,
+		username: username,
+		password: password,
 	}, nil
 }
 
+// String returns the URL without any credentials it was parsed with.
 func (u *URL) String() string {
+	if u.opaque {
+		return u.scheme + ":" + u.path
+	}
 	return fmt.Sprintf("%s://%s%s", u.scheme, u.host, u.path)
 }
+
+// Scheme returns the scheme of the URL, such as "https".
+func (u *URL) Scheme() string {
+	return u.scheme
+}
+
+// Host returns the host of the URL, with the port if it names one. Opaque
+// URLs have no host.
+func (u *URL) Host() string {
+	return u.host
+}
+
+// Path returns the path of the URL, or for opaque URLs like "data:,hi"
+// everything after the scheme.
+func (u *URL) Path() string {
+	return u.path
+}
+
+// hostWithPort returns the host to connect to, adding the scheme's default
+// port when the URL doesn't name one.
+func (u *URL) hostWithPort() string {
+	if strings.Contains(u.host, ":") {
+		return u.host
+	}
+	return fmt.Sprintf("%s:%s", u.host, u.port)
+}
+
+// origin returns the scheme, host and port requests to u go to.
+func (u *URL) origin() string {
+	return u.scheme + "://" + u.hostWithPort()
+}
+
+// hostname returns the host without any port.
+func (u *URL) hostname() string {
+	host, _, err := net.SplitHostPort(u.hostWithPort())
+	if err != nil {
+		return u.host
+	}
+	return host
+}
@@ -1,29 +1,241 @@
 package main
 
 import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/MaxIvanyshen/browser-engineering-go/engine"
 	"github.com/MaxIvanyshen/browser-engineering-go/utils"
 )
 
 func main() {
-	if len(os.Args) < 2 {
+	cacheDir := flag.String("cache-dir", defaultCacheDir(), "directory for the persistent HTTP cache (empty disables it)")
+	caCerts := flag.String("cacert", "", "comma-separated PEM files with CA certificates to trust instead of the system roots")
+	clientCert := flag.String("cert", "", "PEM client certificate for mutual TLS")
+	clientKey := flag.String("key", "", "PEM private key for -cert")
+	tlsMin := flag.String("tls-min", "", "minimum TLS version (1.0, 1.1, 1.2 or 1.3)")
+	tlsMax := flag.String("tls-max", "", "maximum TLS version (1.0, 1.1, 1.2 or 1.3)")
+	serverName := flag.String("servername", "", "override the TLS server name sent and verified")
+	insecure := flag.Bool("insecure", false, "skip TLS certificate verification (local testing only)")
+	security := flag.Bool("security", false, "print connection and certificate details before the page")
+	record := flag.String("record", "", "save every request and response of the page load to this archive")
+	replay := flag.String("replay", "", "load the page from this archive instead of the network")
+	harPath := flag.String("har", "", "write the page load to this file in HAR format")
+	verbose := flag.Bool("verbose", false, "log request events to stderr")
+	resolve := flag.String("resolve", "", "comma-separated host=address overrides for name resolution")
+	downloadDir := flag.String("download-dir", defaultDownloadDir(), "directory to save responses that can't be displayed (empty keeps them in memory)")
+	network := flag.String("network", "", "emulate a network: "+strings.Join(engine.NetworkPresetNames(), ", "))
+	eyeballsDelay := flag.Duration("happy-eyeballs-delay", engine.DEFAULT_HAPPY_EYEBALLS_DELAY, "head start for each connection attempt before racing the next address")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
 		println("Please provide a URL as an argument.")
 		return
 	}
 
-	e := engine.NewEngine()
+	opts := []engine.Option{engine.WithHappyEyeballsDelay(*eyeballsDelay)}
+	if isTerminal(os.Stdin) {
+		opts = append(opts, engine.WithCredentials(promptCredentials))
+	}
+	if *verbose {
+		logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		opts = append(opts, engine.WithObserver(engine.SlogObserver(logger)))
+	}
+	if *network != "" {
+		conditions, ok := engine.NetworkPreset(*network)
+		if !ok {
+			panic(fmt.Sprintf("unknown -network %q, expected one of %s", *network, strings.Join(engine.NetworkPresetNames(), ", ")))
+		}
+		opts = append(opts, engine.WithNetworkConditions(conditions))
+	}
+	if *downloadDir != "" {
+		opts = append(opts, engine.WithDownloadDir(*downloadDir))
+		opts = append(opts, engine.WithObserver(engine.ObserverFunc(showDownloadProgress)))
+	}
+	var har *engine.HAR
+	if *harPath != "" {
+		har = engine.NewHAR()
+		opts = append(opts, engine.WithHAR(har))
+	}
+	var archive *engine.Archive
+	if *record != "" {
+		archive = engine.NewArchive()
+		opts = append(opts, engine.WithRecorder(archive))
+	}
+	if *replay != "" {
+		replayed, err := engine.LoadArchive(*replay)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, engine.WithReplay(replayed))
+	}
+
+	// Recording and replaying bypass the persistent cache so that every
+	// request reaches the network or the archive.
+	var diskCache *engine.DiskCache
+	if *cacheDir != "" && *record == "" && *replay == "" {
+		storage, err := engine.OpenDiskCache(*cacheDir, engine.DEFAULT_CACHE_MAX_BYTES, engine.DEFAULT_CACHE_MAX_ENTRIES)
+		if err != nil {
+			panic(err)
+		}
+		diskCache = storage
+		opts = append(opts, engine.WithCacheStorage(storage))
+
+		hsts, err := engine.OpenHSTSStore(filepath.Join(*cacheDir, "hsts.json"))
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, engine.WithHSTSStore(hsts))
+	}
+
+	if *resolve != "" {
+		hosts := make(map[string]string)
+		for _, entry := range strings.Split(*resolve, ",") {
+			host, addr, ok := strings.Cut(entry, "=")
+			if !ok {
+				panic(fmt.Sprintf("invalid -resolve entry %q, expected host=address", entry))
+			}
+			hosts[host] = addr
+		}
+		opts = append(opts, engine.WithHosts(hosts))
+	}
+
+	tlsOpts := engine.TLSOptions{
+		ClientCertFile:     *clientCert,
+		ClientKeyFile:      *clientKey,
+		ServerName:         *serverName,
+		InsecureSkipVerify: *insecure,
+	}
+	if *caCerts != "" {
+		tlsOpts.RootCAFiles = strings.Split(*caCerts, ",")
+	}
+	if *tlsMin != "" {
+		version, err := engine.ParseTLSVersion(*tlsMin)
+		if err != nil {
+			panic(err)
+		}
+		tlsOpts.MinVersion = version
+	}
+	if *tlsMax != "" {
+		version, err := engine.ParseTLSVersion(*tlsMax)
+		if err != nil {
+			panic(err)
+		}
+		tlsOpts.MaxVersion = version
+	}
+	tlsConfig, err := engine.LoadTLSConfig(tlsOpts)
+	if err != nil {
+		panic(err)
+	}
+	opts = append(opts, engine.WithTLSConfig(tlsConfig))
+
+	e := engine.NewEngine(opts...)
 
-	url, err := engine.Parse(os.Args[1])
+	url, err := engine.Parse(flag.Arg(0))
 	if err != nil {
 		panic(err)
 	}
 
 	resp, err := e.Request(url, nil)
+	e.Close()
+	// Closing the cache saves which entries this run used.
+	if diskCache != nil {
+		if err := diskCache.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to save the cache index: %v\n", err)
+		}
+	}
+	if archive != nil {
+		if err := archive.Save(*record); err != nil {
+			panic(err)
+		}
+	}
+	if har != nil {
+		if err := har.Save(*harPath); err != nil {
+			panic(err)
+		}
+	}
 	if err != nil {
-		panic(err)
+		utils.Show(utils.ErrorPage(url.String(), err))
+		os.Exit(1)
 	}
 
+	if *security {
+		utils.ShowSecurity(resp)
+		fmt.Println()
+	}
 	utils.Show(resp)
 }
+
+// stdin is shared by every prompt, so that input one prompt reads ahead
+// isn't lost to the next.
+var stdin = bufio.NewReader(os.Stdin)
+
+// promptCredentials asks the user on the terminal for the credentials a
+// server wants.
+func promptCredentials(req engine.AuthRequest) (string, string, bool) {
+	if req.Attempt > 1 {
+		fmt.Fprintln(os.Stderr, "Those credentials were rejected.")
+	}
+	fmt.Fprintf(os.Stderr, "%s requires a username and password (%s realm %q)\n", req.Host, req.Scheme, req.Realm)
+	fmt.Fprint(os.Stderr, "Username: ")
+	username, err := stdin.ReadString('\n')
+	if err != nil {
+		return "", "", false
+	}
+	username = strings.TrimRight(username, "\r\n")
+	if username == "" {
+		return "", "", false
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	if restore, err := disableEcho(os.Stdin); err != nil {
+		fmt.Fprintf(os.Stderr, "\nwarning: the password will be shown as you type it: %v\nPassword: ", err)
+	} else {
+		defer func() {
+			restore()
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	password, err := stdin.ReadString('\n')
+	if err != nil {
+		return "", "", false
+	}
+	return username, strings.TrimRight(password, "\r\n"), true
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// showDownloadProgress keeps a progress line for the current download on
+// stderr.
+func showDownloadProgress(event engine.Event) {
+	if ev, ok := event.(engine.DownloadProgressEvent); ok {
+		fmt.Fprintf(os.Stderr, "\r\033[KDownloading %s", utils.DownloadProgress(ev))
+		if ev.Done {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+func defaultDownloadDir() string {
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "Downloads")
+}
+
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "browser-engineering-go")
+}
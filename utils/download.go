@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MaxIvanyshen/browser-engineering-go/engine"
+)
+
+// DownloadSummary describes where a downloaded response was saved, in
+// place of showing its body.
+func DownloadSummary(resp *engine.Response) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Downloaded %s\n", resp.URL)
+	writeField(&b, "Saved to", resp.Download.Path)
+	writeField(&b, "Size", formatBytes(resp.Download.Size))
+	writeField(&b, "SHA-256", resp.Download.SHA256)
+	return b.String()
+}
+
+// DownloadProgress formats how far along a download is, e.g.
+// "report.pdf: 1.5 MB of 3.0 MB (50%)".
+func DownloadProgress(ev engine.DownloadProgressEvent) string {
+	name := ev.Path[strings.LastIndexAny(ev.Path, `/\`)+1:]
+	if ev.Total < 0 {
+		return fmt.Sprintf("%s: %s", name, formatBytes(ev.Received))
+	}
+	percent := 100
+	if ev.Total > 0 {
+		percent = int(ev.Received * 100 / ev.Total)
+	}
+	return fmt.Sprintf("%s: %s of %s (%d%%)", name, formatBytes(ev.Received), formatBytes(ev.Total), percent)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	value, prefix := float64(n)/unit, 0
+	for value >= unit && prefix < 3 {
+		value /= unit
+		prefix++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "KMGT"[prefix])
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/MaxIvanyshen/browser-engineering-go/engine"
+)
+
+func TestDownloadSummary(t *testing.T) {
+	resp := &engine.Response{
+		URL: "https://example.com/files/report.pdf",
+		Download: &engine.DownloadInfo{
+			Path:   "/home/user/Downloads/report (1).pdf",
+			Size:   1536,
+			SHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+	}
+	expected := []string{
+		"Downloaded https://example.com/files/report.pdf",
+		"Saved to:        /home/user/Downloads/report (1).pdf",
+		"Size:            1.5 KB",
+		"SHA-256:         e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	}
+	output := DownloadSummary(resp)
+	for _, line := range expected {
+		if !strings.Contains(output, line) {
+			t.Errorf("expected %q in output:\n%s", line, output)
+		}
+	}
+}
+
+func TestDownloadProgress(t *testing.T) {
+	tests := []struct {
+		ev       engine.DownloadProgressEvent
+		expected string
+	}{
+		{engine.DownloadProgressEvent{Path: "/tmp/a.zip", Received: 512, Total: -1}, "a.zip: 512 bytes"},
+		{engine.DownloadProgressEvent{Path: "/tmp/a.zip", Received: 3 << 20, Total: 6 << 20}, "a.zip: 3.0 MB of 6.0 MB (50%)"},
+		{engine.DownloadProgressEvent{Path: "/tmp/empty", Received: 0, Total: 0}, "empty: 0 bytes of 0 bytes (100%)"},
+	}
+	for _, tt := range tests {
+		if got := DownloadProgress(tt.ev); got != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"strings"
+
+	"github.com/MaxIvanyshen/browser-engineering-go/engine"
+)
+
+type errorPageText struct {
+	kind    error
+	title   string
+	message string
+	code    string
+}
+
+// escapeHTML escapes the characters Show decodes back.
+var escapeHTML = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+
+// errorPages is checked in order, so the more specific kinds come first.
+var errorPages = []errorPageText{
+	{engine.ErrOffline, "No internet", "%s can't be reached while the network is offline.", "ERR_INTERNET_DISCONNECTED"},
+	{engine.ErrUnknownPage, "This page isn't available", "%s is not a page this browser has.", "ERR_INVALID_URL"},
+	{engine.ErrNotInArchive, "This page isn't in the archive", "%s was not recorded in the archive being replayed.", "ERR_CACHE_MISS"},
+	{engine.ErrTooManyRedirects, "This page isn't working", "%s redirected you too many times.", "ERR_TOO_MANY_REDIRECTS"},
+	{engine.ErrDNS, "This site can't be reached", "%s's server IP address could not be found.", "ERR_NAME_NOT_RESOLVED"},
+	{engine.ErrTimeout, "This site can't be reached", "%s took too long to respond.", "ERR_TIMED_OUT"},
+	{engine.ErrTLS, "This site can't provide a secure connection", "%s sent an invalid response or certificate.", "ERR_SSL_PROTOCOL_ERROR"},
+	{engine.ErrConnect, "This site can't be reached", "%s refused to connect or closed the connection.", "ERR_CONNECTION_FAILED"},
+	{engine.ErrDecode, "This page isn't working", "%s sent content that could not be decoded.", "ERR_CONTENT_DECODING_FAILED"},
+	{engine.ErrProtocol, "This page isn't working", "%s sent an invalid response.", "ERR_INVALID_RESPONSE"},
+}
+
+// ErrorPage builds the page a browser would show in place of url when
+// loading it failed with err. It can be passed to Show like any other
+// response.
+func ErrorPage(url string, err error) *engine.Response {
+	page := errorPageText{
+		title:   "This page couldn't be loaded",
+		message: "%s could not be loaded.",
+		code:    "ERR_FAILED",
+	}
+	for _, p := range errorPages {
+		if errors.Is(err, p.kind) {
+			page = p
+			break
+		}
+	}
+
+	host := url
+	if parsed, parseErr := neturl.Parse(url); parseErr == nil && parsed.Hostname() != "" {
+		host = parsed.Hostname()
+	}
+
+	body := fmt.Sprintf("<html><body>\n<h1>%s</h1>\n<p>%s</p>\n<p>%s</p>\n<p>%s</p>\n</body></html>\n",
+		escapeHTML(page.title),
+		escapeHTML(fmt.Sprintf(page.message, host)),
+		escapeHTML(err.Error()),
+		page.code,
+	)
+	return &engine.Response{
+		URL:     url,
+		Headers: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+		Body:    []byte(body),
+	}
+}
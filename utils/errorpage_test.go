@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/MaxIvanyshen/browser-engineering-go/engine"
+)
+
+func TestErrorPage(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		err      error
+		expected []string
+	}{
+		{
+			name:     "DNS",
+			url:      "http://example.invalid/",
+			err:      &engine.Error{Kind: engine.ErrDNS, URL: "http://example.invalid/", Err: errors.New("no such host")},
+			expected: []string{"This site can't be reached", "example.invalid's server IP address", "ERR_NAME_NOT_RESOLVED"},
+		},
+		{
+			name:     "Redirect loop",
+			url:      "http://example.com/",
+			err:      &engine.Error{Kind: engine.ErrTooManyRedirects, URL: "http://example.com/"},
+			expected: []string{"This page isn't working", "example.com redirected you too many times.", "ERR_TOO_MANY_REDIRECTS"},
+		},
+		{
+			name:     "Offline",
+			url:      "https://example.com/",
+			err:      &engine.Error{Kind: engine.ErrConnect, URL: "https://example.com/", Err: engine.ErrOffline},
+			expected: []string{"No internet", "example.com can't be reached while the network is offline.", "ERR_INTERNET_DISCONNECTED"},
+		},
+		{
+			name:     "Unknown about page",
+			url:      "about:nothing",
+			err:      &engine.Error{Kind: engine.ErrUnknownPage, URL: "about:nothing"},
+			expected: []string{"This page isn't available", "about:nothing is not a page this browser has.", "ERR_INVALID_URL"},
+		},
+		{
+			name:     "Other errors",
+			url:      "gopher://example.com/",
+			err:      errors.New("unsupported scheme: gopher"),
+			expected: []string{"could not be loaded", "unsupported scheme: gopher", "ERR_FAILED"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := ErrorPage(tt.url, tt.err)
+			for _, want := range tt.expected {
+				if !strings.Contains(string(page.Body), want) {
+					t.Errorf("expected page to contain %q, got:\n%s", want, page.Body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"github.com/MaxIvanyshen/browser-engineering-go/engine"
+)
+
+// ShowSecurity prints what a browser's "connection is secure" panel would
+// show for resp: how the connection was set up and the certificate chain.
+func ShowSecurity(resp *engine.Response) {
+	fmt.Print(SecuritySummary(resp))
+}
+
+// SecuritySummary formats the connection details of resp for display.
+func SecuritySummary(resp *engine.Response) string {
+	var b strings.Builder
+
+	switch {
+	case resp.TLS == nil:
+		fmt.Fprintf(&b, "Connection to %s is not secure\n", resp.URL)
+	case !resp.TLS.Verified:
+		fmt.Fprintf(&b, "Connection to %s is not secure: the certificate wasn't verified\n", resp.URL)
+	default:
+		fmt.Fprintf(&b, "Connection to %s is secure\n", resp.URL)
+	}
+	if resp.TLS != nil {
+		writeField(&b, "Protocol", resp.TLS.VersionName())
+		writeField(&b, "Cipher suite", resp.TLS.CipherSuiteName())
+		if resp.TLS.NegotiatedProtocol != "" {
+			writeField(&b, "ALPN", resp.TLS.NegotiatedProtocol)
+		}
+		if resp.TLS.ServerName != "" {
+			writeField(&b, "Server name", resp.TLS.ServerName)
+		}
+	}
+
+	if conn := resp.Connection; conn != nil {
+		if conn.RemoteAddr != "" {
+			writeField(&b, "Remote address", conn.RemoteAddr)
+		}
+		if conn.Proxy != "" {
+			writeField(&b, "Proxy", conn.Proxy)
+		}
+		reused := "no"
+		if conn.Reused {
+			reused = "yes"
+		}
+		writeField(&b, "Reused", reused)
+	}
+
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		b.WriteString("Certificate chain:\n")
+		for i, cert := range resp.TLS.PeerCertificates {
+			fingerprint := sha256.Sum256(cert.Raw)
+			fmt.Fprintf(&b, "  %d: %s\n", i, cert.Subject)
+			writeField(&b, "  Issued by", cert.Issuer.String())
+			writeField(&b, "  Valid", cert.NotBefore.UTC().Format("2006-01-02")+" to "+cert.NotAfter.UTC().Format("2006-01-02"))
+			if len(cert.DNSNames) > 0 {
+				writeField(&b, "  Names", strings.Join(cert.DNSNames, ", "))
+			}
+			writeField(&b, "  SHA-256", formatFingerprint(fingerprint[:]))
+		}
+	}
+
+	return b.String()
+}
+
+func writeField(b *strings.Builder, label, value string) {
+	fmt.Fprintf(b, "  %-16s %s\n", label+":", value)
+}
+
+func formatFingerprint(sum []byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
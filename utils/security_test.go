@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+
+	"github.com/MaxIvanyshen/browser-engineering-go/engine"
+)
+
+func TestSecuritySummary(t *testing.T) {
+	tests := []struct {
+		name     string
+		resp     *engine.Response
+		expected []string
+	}{
+		{
+			name: "Plain http",
+			resp: &engine.Response{
+				URL:        "http://example.com/",
+				Connection: &engine.ConnectionInfo{RemoteAddr: "93.184.216.34:80"},
+			},
+			expected: []string{
+				"Connection to http://example.com/ is not secure",
+				"Remote address:  93.184.216.34:80",
+				"Reused:          no",
+			},
+		},
+		{
+			name: "https",
+			resp: &engine.Response{
+				URL: "https://example.com/",
+				TLS: &engine.TLSInfo{
+					Version:            tls.VersionTLS13,
+					CipherSuite:        tls.TLS_AES_128_GCM_SHA256,
+					NegotiatedProtocol: "http/1.1",
+					Verified:           true,
+				},
+				Connection: &engine.ConnectionInfo{RemoteAddr: "93.184.216.34:443", Reused: true},
+			},
+			expected: []string{
+				"Connection to https://example.com/ is secure",
+				"Protocol:        TLS 1.3",
+				"Cipher suite:    TLS_AES_128_GCM_SHA256",
+				"ALPN:            http/1.1",
+				"Reused:          yes",
+			},
+		},
+		{
+			name: "https without verification",
+			resp: &engine.Response{
+				URL: "https://localhost/",
+				TLS: &engine.TLSInfo{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256},
+			},
+			expected: []string{
+				"Connection to https://localhost/ is not secure: the certificate wasn't verified",
+				"Protocol:        TLS 1.3",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := SecuritySummary(tt.resp)
+			for _, line := range tt.expected {
+				if !strings.Contains(output, line) {
+					t.Errorf("expected %q in output:\n%s", line, output)
+				}
+			}
+		})
+	}
+}
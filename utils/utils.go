@@ -16,6 +16,15 @@ var entityMap = map[string]string{
 }
 
 func Show(resp *engine.Response) {
+	// Binary bodies would only garble the terminal.
+	if resp.Download != nil {
+		fmt.Print(DownloadSummary(resp))
+		return
+	}
+	if engine.IsDownload(resp) {
+		fmt.Printf("Not showing %s of binary content from %s\n", formatBytes(int64(len(resp.Body))), resp.URL)
+		return
+	}
 	if resp.ViewSource {
 		fmt.Println(string(resp.Body))
 		return
@@ -39,6 +39,16 @@ func TestShow(t *testing.T) {
 			},
 			expected: "Hello, World!",
 		},
+		{
+			name: "Binary body",
+			resp: &engine.Response{
+				URL:        "http://example.com/archive.zip",
+				StatusCode: 200,
+				Headers:    map[string]string{"Content-Type": "application/zip"},
+				Body:       []byte("PK\x03\x04<binary>"),
+			},
+			expected: "Not showing 12 bytes of binary content from http://example.com/archive.zip\n",
+		},
 	}
 
 	for _, tt := range tests {